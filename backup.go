@@ -1,142 +1,467 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/file"
 	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
 	"github.com/MasteryConnect/honeybadger-s3/s3"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
 	log "github.com/Sirupsen/logrus"
-	"strings"
-	"time"
 )
 
+// Files under the prefix that remember when each project was backed up to.
+// The run data is the legacy text format, migrated to the JSON state
+const (
+	STATE_FILE    = "honeybadger-s3-state.json"
+	RUN_DATA_FILE = "honeybadger-s3-run-data.txt"
+)
+
+// The lock object under the prefix held by the run backing up to it
+const LOCK_FILE = "honeybadger-s3.lock"
+
+// The directory under the prefix of the index of the notices archived of each
+// project, a file per project and day
+const NOTICE_INDEX_DIR = "index"
+
+// Returned by a run that was stopped before it finished, e.g. when the daemon
+// is signalled
+var ErrStopped = errors.New("the run was stopped")
+
+// Key templates of the object key layouts
+var layouts = map[string]string{
+	"flat": "[{prefix}/][{project}-]{type}-{run_id}{ext}",
+	"hive": "[{prefix}/]type={type}/[project={project_lower}/]dt={date}/hour={hh}/{run_id}{ext}",
+}
+
 type Context struct {
-	S3bucket           string
-	S3prefix           string
-	HoneybadgerKey     string
-	ProjectIncludeList string
-	LastRun            string
-	RunData            *s3.RunData
-	UploadedFiles      []string
+	S3bucket            string
+	S3prefix            string
+	Destination         string
+	Store               storage.Store
+	HoneybadgerKey      string
+	HoneybadgerEndpoint string
+	ProjectIncludeList  string
+	LastRun             string
+	Raw                 bool                 // Archive the exact JSON returned by the API
+	Output              storage.Output       // How records are written to each object
+	KeyTemplate         *storage.KeyTemplate // How object keys are named
+	Environment         string
+	RunStart            time.Time
+	LockLease           time.Duration // How long the lock is held without a heartbeat
+	Lock                *storage.Lock
+	WatermarkOverlap    time.Duration       // How far before the watermarks to look again for late records
+	CheckpointInterval  time.Duration       // How often a project's progress is checkpointed, 0 to never
+	Checkpoints         map[int]*Checkpoint // Saved by this run or left by an interrupted one, by project ID
+	RunData             *storage.RunData
+	Manifest            *storage.Manifest   // The objects this run completed
+	Skipped             bool                // Another run held the lock, so this one didn't back up
+	Stop                <-chan struct{}     // Closed to stop the run at the next page, if set
+	Locked              func(*storage.Lock) // Called once the run holds the lock, if set
 }
 
-func backup(ctx *Context) {
-	log.WithFields(log.Fields{"bucket": ctx.S3bucket, "prefix": ctx.S3prefix, "projects": ctx.ProjectIncludeList}).Info("Backup ctx: ")
+// ErrStopped once the run has been told to stop
+func stopped(ctx *Context) error {
+	select {
+	case <-ctx.Stop:
+		return ErrStopped
+	default:
+		return nil
+	}
+}
+
+func backup(ctx *Context) error {
+	log.WithFields(log.Fields{"bucket": ctx.S3bucket, "destination": ctx.Destination, "prefix": ctx.S3prefix, "projects": ctx.ProjectIncludeList}).Info("Backup ctx: ")
+
+	store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Store = store
+	ctx.RunStart = time.Now()
+	ctx.Manifest = storage.NewManifest(ctx.RunStart)
+
+	// Only one run backs up to the prefix at a time. Otherwise runs would back
+	// up the same faults, and clean up each other's uploads
+	ctx.Lock = storage.NewLock(ctx.Store, storage.JoinKey(ctx.S3prefix, LOCK_FILE), ctx.Manifest.RunId, ctx.LockLease)
+	err = ctx.Lock.Acquire()
+	if err == storage.ErrLocked {
+		log.Info("Skipping this run")
+		ctx.Skipped = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ctx.Locked != nil {
+		ctx.Locked(ctx.Lock)
+	}
+	defer func() {
+		if releaseErr := ctx.Lock.Release(); releaseErr != nil {
+			log.WithFields(log.Fields{"lock": ctx.Lock.Key}).Error(releaseErr)
+		}
+	}()
 
 	// s3.FindAllFailedUploads()
 
-	s3.CleanUpFailedUploads(ctx.S3bucket, ctx.S3prefix)
+	// Uploads checkpointed by an interrupted run are carried on rather than
+	// cleaned up, unless the run starts over from --last-run
+	ctx.Checkpoints = map[int]*Checkpoint{}
+	if len(ctx.LastRun) < 1 {
+		if ctx.Checkpoints, err = loadCheckpoints(ctx); err != nil {
+			return err
+		}
+	}
+	ctx.Store.CleanUpFailedUploads(ctx.S3prefix, checkpointedKeys(ctx.Checkpoints))
 
-	runNewBackup(ctx)
+	err = runNewBackup(ctx)
 
-	if len(ctx.UploadedFiles) > 0 {
+	if len(ctx.Manifest.Objects) > 0 {
 		log.Info("List of uploaded files:")
 	}
-	for _, v := range ctx.UploadedFiles {
-		log.Info(v)
+	for _, v := range ctx.Manifest.Objects {
+		log.Info(v.Location)
 	}
+	if ctx.Manifest.Duplicates > 0 {
+		log.WithFields(log.Fields{"duplicates": ctx.Manifest.Duplicates}).Info("Suppressed notices already archived")
+	}
+	// Write the manifest even when the run failed, it lists what did complete
+	ctx.Manifest.RunEnd = time.Now()
+	if err != nil {
+		ctx.Manifest.Error = err.Error()
+	}
+	manifestKey := storage.ManifestKey(ctx.S3prefix, ctx.RunStart)
+	if manifestErr := ctx.Manifest.Save(ctx.Store, manifestKey); manifestErr != nil {
+		log.WithFields(log.Fields{"manifest": manifestKey}).Error(manifestErr)
+		if err == nil {
+			err = manifestErr
+		}
+	} else {
+		log.WithFields(log.Fields{"manifest": manifestKey}).Info("Wrote the run manifest")
+	}
+	return err
 }
 
-func runNewBackup(ctx *Context) {
+func runNewBackup(ctx *Context) error {
 	// Get the RunData, including last run for now.
-	ctx.RunData = s3.NewRunData(ctx.S3bucket, ctx.S3prefix+"/honeybadger-s3-run-data.txt", ctx.LastRun)
+	runData, err := storage.NewRunData(ctx.Store, storage.JoinKey(ctx.S3prefix, STATE_FILE), storage.JoinKey(ctx.S3prefix, RUN_DATA_FILE), ctx.LastRun)
+	if err != nil {
+		return err
+	}
+	ctx.RunData = runData
 
 	// Get a list of honeybadger projects, filter to only those we want to backup
-	projects := hb.NewProjects(ctx.ProjectIncludeList, ctx.HoneybadgerKey)
+	projects := hb.NewProjects(ctx.HoneybadgerEndpoint, ctx.ProjectIncludeList, ctx.HoneybadgerKey)
 	// Create the project upload
-	s3Projects := s3.NewUpload(ctx.S3bucket, constructS3FilePath(ctx.S3prefix, "projects"))
-	err := s3Projects.CreateUpload()
+	s3Projects := newUpload(ctx, "projects", nil)
+	err = s3Projects.CreateUpload()
 	if err != nil {
 		s3Projects.HandleError(err)
-		return
+		return err
 	}
+	var failed []string // Projects that failed to back up
+	backedUp := 0
 	for project, more := projects.Next(); more; project, more = projects.Next() {
+		if err = ctx.Lock.Err(); err != nil {
+			break
+		}
+		if err = stopped(ctx); err != nil {
+			break
+		}
 		log.WithFields(log.Fields{"project": project.Name}).Info("Backing up")
-		err := backupProject(ctx, project, s3Projects)
-		if err != nil {
-			return
+		projectErr := backupProject(ctx, project, s3Projects)
+		// Another run has taken over, and will save its own run data
+		if err = ctx.Lock.Err(); err != nil {
+			break
+		}
+		// The next run carries on from where the project was checkpointed
+		if checkpoint := ctx.Checkpoints[project.Id]; projectErr == ErrStopped && checkpoint != nil && checkpoint.stopped {
+			err = projectErr
+			break
 		}
+		// Commit where this project got to now, so it isn't backed up again
+		// whatever happens to the rest of the run. A project that failed only
+		// gets as far as the uploads it completed. If this fails, it's saved
+		// with the rest of the run
+		if commitErr := ctx.RunData.CommitProject(ctx.Manifest.RunId, project.Id); commitErr != nil && projectErr == nil {
+			projectErr = commitErr
+		}
+		// The uploads were either completed or aborted, there's nothing left
+		// to resume
+		ctx.Manifest.ClearPending(project.Id)
+		if _, ok := ctx.Checkpoints[project.Id]; ok {
+			deleteCheckpoint(ctx, project.Id)
+		}
+		if projectErr == ErrStopped {
+			err = projectErr
+			break
+		}
+		if projectErr != nil {
+			// Carry on with the other projects. This project is backed up
+			// again next run from what it didn't complete
+			log.WithFields(log.Fields{"project": project.Name}).Error(projectErr)
+			failed = append(failed, project.Name)
+			continue
+		}
+		backedUp++
+	}
+	if err == nil {
+		err = projects.Err()
+	}
+	if err == nil && len(failed) > 0 {
+		err = fmt.Errorf("%d of %d projects failed to back up: %s", len(failed), len(failed)+backedUp, strings.Join(failed, ", "))
 	}
-	// Complete the project uploads
-	location, err := s3Projects.CompleteUpload()
+	// Complete the project uploads. Only projects that were fully backed up
+	// were uploaded. Their timestamps are already committed, so the projects
+	// file is all that's lost if this fails
+	locations, completeErr := s3Projects.CompleteUpload()
+	ctx.Manifest.ClearPending(0)
+	if completeErr != nil {
+		s3Projects.HandleError(completeErr)
+		return completeErr
+	}
+	ctx.Manifest.Add("", 0, "projects", locations)
+	// Another run has taken over, and will save its own run data
+	if lockErr := ctx.Lock.Err(); lockErr != nil {
+		return lockErr
+	}
+	if saveErr := ctx.RunData.SaveNextRun(ctx.Manifest.RunId, err); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func backupProject(ctx *Context, project *hb.Project, s3Projects storage.Upload) error {
+	s3Faults, s3Notices, checkpoint, err := startProject(ctx, project)
 	if err != nil {
-		s3Projects.HandleError(err)
-		return
+		return err
+	}
+
+	err = backupFaults(ctx, project, checkpoint, s3Faults, s3Notices)
+	if err == ErrStopped && checkpoint.stopped {
+		log.WithFields(log.Fields{"project": project.Name}).Info("Stopped, the next run carries on from the checkpoint")
+		return err
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"project": project.Name}).Error(err)
+		// The run that took the lock over may be carrying on these uploads
+		// from their checkpoint, so they're left to it
+		if ctx.Lock.Err() != nil {
+			return err
+		}
+		s3Faults.AbortUpload()
+		s3Notices.AbortUpload()
+		return err
 	}
-	err = ctx.RunData.SaveNextRun()
+	// Complete the notice uploads
+	noticesLocation, err := s3Notices.CompleteUpload()
 	if err != nil {
-		log.Fatal(err)
+		s3Notices.HandleError(err)
+		s3Faults.AbortUpload()
+		return err
+	}
+	// The notices are archived whatever happens to the faults, so they're in
+	// the manifest and the index, and the next run doesn't back them up again
+	ctx.Manifest.Add(project.Name, project.Id, "notices", noticesLocation)
+	ctx.Manifest.Duplicates += checkpoint.duplicates
+	// Those missing from the index are only skipped by the watermarks next run
+	if indexErr := checkpoint.index.Save(); indexErr != nil {
+		log.WithFields(log.Fields{"project": project.Name, "index": checkpoint.index.Prefix}).Warn(indexErr)
 	}
-	ctx.UploadedFiles = append(ctx.UploadedFiles, location)
+	// If the faults fail, the next run backs them up again from where they
+	// started, but only looks for notices from where these got to
+	next := checkpoint.next()
+	noticesOnly := next
+	noticesOnly.Faults, noticesOnly.FaultsSeen = checkpoint.Prev.Faults, checkpoint.Prev.FaultsSeen
+	ctx.RunData.SetNext(project.Id, noticesOnly)
+	// Complete the fault uploads
+	faultsLocation, err := s3Faults.CompleteUpload()
+	if err != nil {
+		s3Faults.HandleError(err)
+		return err
+	}
+	ctx.Manifest.Add(project.Name, project.Id, "faults", faultsLocation)
+	// The next run starts from the latest records this one saw
+	ctx.RunData.SetNext(project.Id, next)
+	// Upload this project
+	err = s3Projects.Upload(archived(ctx, project, project.Raw))
+	if err != nil {
+		s3Projects.HandleError(err)
+		return err
+	}
+	return nil
 }
 
-func backupProject(ctx *Context, project *hb.Project, s3Projects *s3.Upload) error {
+// Starts the uploads of a project's faults and notices, or carries them on
+// from where an interrupted run checkpointed them
+func startProject(ctx *Context, project *hb.Project) (s3Faults, s3Notices storage.Upload, checkpoint *Checkpoint, err error) {
+	if checkpoint = ctx.Checkpoints[project.Id]; checkpoint != nil {
+		s3Faults, s3Notices, err = resumeUploads(ctx, project, checkpoint)
+		if err == nil {
+			if checkpoint.index, err = loadNoticeIndex(ctx, project.Id, checkpoint); err != nil {
+				s3Faults.AbortUpload()
+				s3Notices.AbortUpload()
+				return nil, nil, nil, err
+			}
+			log.WithFields(log.Fields{"project": project.Name, "run": checkpoint.RunId, "checkpointed": checkpoint.SavedAt}).Info("Resuming from checkpoint")
+			checkpoint.track(ctx.WatermarkOverlap)
+			return s3Faults, s3Notices, checkpoint, nil
+		}
+		// e.g. the uploads were cleaned up since
+		log.WithFields(log.Fields{"project": project.Name}).Warn("Can't resume from checkpoint, starting over: ", err)
+		deleteCheckpoint(ctx, project.Id)
+	}
+
+	prev, err := ctx.RunData.GetPrevTimestamps(project.Id, project.Name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	checkpoint = &Checkpoint{
+		RunId:     ctx.Manifest.RunId,
+		ProjectId: project.Id,
+		Project:   project.Name,
+		Prev:      *prev,
+		Next:      *prev,
+	}
+	checkpoint.track(ctx.WatermarkOverlap)
+	if checkpoint.index, err = loadNoticeIndex(ctx, project.Id, nil); err != nil {
+		return nil, nil, nil, err
+	}
 	// Create the fault upload
-	s3Faults := s3.NewUpload(ctx.S3bucket, constructS3FilePath(ctx.S3prefix, project.Name, "faults"))
-	err := s3Faults.CreateUpload()
+	s3Faults = newUpload(ctx, "faults", project)
+	err = s3Faults.CreateUpload()
 	if err != nil {
 		s3Faults.HandleError(err)
-		return err
+		return nil, nil, nil, err
 	}
 	// Create the notice upload
-	s3Notices := s3.NewUpload(ctx.S3bucket, constructS3FilePath(ctx.S3prefix, project.Name, "notices"))
+	s3Notices = newUpload(ctx, "notices", project)
 	err = s3Notices.CreateUpload()
 	if err != nil {
 		s3Notices.HandleError(err)
-		return err
+		s3Faults.AbortUpload()
+		return nil, nil, nil, err
 	}
+	return s3Faults, s3Notices, checkpoint, nil
+}
 
+// Backs up the project's faults, and the notices of each fault, since the
+// project's previous timestamps. Progress is checkpointed between pages of
+// faults and of notices, and the backup stops between pages once the run has
+// lost the lock, or checkpoints and stops once the run is told to stop
+func backupFaults(ctx *Context, project *hb.Project, checkpoint *Checkpoint, s3Faults storage.Upload, s3Notices storage.Upload) error {
 	// Get the projects faults
-	lastRunTimestamp, err := ctx.RunData.GetPrevTimestamp(project.Name)
-	if err != nil {
-		s3Faults.HandleError(err) // FIXME: Should abort all uploads
-		return err
+	faults := hb.NewFaults(ctx.HoneybadgerEndpoint, project.Id, ctx.HoneybadgerKey, checkpoint.faults.After())
+	// A checkpoint taken partway through a fault's notices starts that fault's
+	// page over, skipping the faults on it that were done
+	resume := checkpoint.Fault
+	done := map[int]bool{}
+	if resume != nil {
+		faults.Seek(resume.Page)
+		for _, id := range resume.Done {
+			done[id] = true
+		}
+	} else if checkpoint.Faults != nil {
+		faults.Seek(*checkpoint.Faults)
+	}
+	faultCount := checkpoint.FaultCount
+	var pageDone []int // The faults done on the current page
+	checkpointing := ctx.CheckpointInterval > 0 && ctx.Output.Format != storage.FormatParquet
+	lastCheckpoint := time.Now()
+	pageFinished := func() error {
+		if err := ctx.Lock.Err(); err != nil {
+			return err
+		}
+		// A run that's told to stop checkpoints straight away
+		stop := stopped(ctx)
+		if !checkpointing || (stop == nil && time.Since(lastCheckpoint) < ctx.CheckpointInterval) {
+			return stop
+		}
+		checkpoint.FaultCount = faultCount
+		if checkpoint.Fault != nil {
+			// The fault in progress is counted again when it's resumed
+			checkpoint.FaultCount--
+		}
+		if err := saveCheckpoint(ctx, checkpoint, faults.Cursor(), s3Faults, s3Notices); err != nil {
+			// Carry on, the project is just started over if the run is interrupted
+			log.WithFields(log.Fields{"project": project.Name}).Warn("Checkpoint failed: ", err)
+			return stop
+		}
+		lastCheckpoint = time.Now()
+		checkpoint.stopped = stop != nil
+		return stop
+	}
+	faults.PageDone = func() error {
+		pageDone, done = nil, map[int]bool{}
+		return pageFinished()
 	}
-	faults := hb.NewFaults(project.Id, ctx.HoneybadgerKey, lastRunTimestamp)
-	faultCount := 0
 	for fault, more := faults.Next(); more; fault, more = faults.Next() {
+		if done[fault.Id] {
+			pageDone = append(pageDone, fault.Id)
+			continue
+		}
 		faultCount++
 		log.WithFields(
 			log.Fields{
 				"count": faultCount,
 				"total": faults.TotalCount},
 		).Info("Faults")
-		err := backupFault(ctx, fault, s3Faults, s3Notices, faultCount, faults.TotalCount, lastRunTimestamp)
+		// A late notice doesn't change its fault's latest notice time, so a
+		// fault the previous run archived as it is still has its notices
+		// listed. It's only archived again if it has notices to archive
+		key := strconv.Itoa(fault.Id) + "@" + strconv.FormatInt(fault.Timestamp().Unix(), 10)
+		checkpoint.faults.Add(key, fault.Timestamp())
+		progress := &FaultProgress{Id: fault.Id}
+		if resume != nil && resume.Id == fault.Id {
+			progress, resume = resume, nil
+		}
+		progress.Page, progress.Done = faults.PageCursor(), append([]int{}, pageDone...)
+		checkpoint.Fault = progress
+		err := backupFault(ctx, checkpoint, fault, checkpoint.faults.Archived(key), progress, s3Faults, s3Notices, faultCount, faults.TotalCount, pageFinished)
 		if err != nil {
 			return err
 		}
+		checkpoint.Fault = nil
+		pageDone = append(pageDone, fault.Id)
+	}
+	if err := faults.Err(); err != nil {
+		return err
 	}
 	if faultCount == 0 {
 		log.Info("No faults to backup")
 	}
-	// Complete the notice uploads
-	noticesLocation, err := s3Notices.CompleteUpload()
-	if err != nil {
-		s3Notices.HandleError(err)
-		return err
-	}
-	// Complete the fault uploads
-	faultsLocation, err := s3Faults.CompleteUpload()
-	if err != nil {
-		s3Faults.HandleError(err)
-		return err
+	if checkpoint.skipped > 0 {
+		log.WithFields(log.Fields{"project": project.Name, "skipped": checkpoint.skipped}).Info("Skipped faults and notices already backed up")
 	}
-	// Upload this project
-	err = s3Projects.Upload(project)
-	if err != nil {
-		s3Projects.HandleError(err)
-		return err
+	if checkpoint.duplicates > 0 {
+		log.WithFields(log.Fields{"project": project.Name, "duplicates": checkpoint.duplicates}).Info("Suppressed notices already archived")
 	}
-	ctx.UploadedFiles = append(ctx.UploadedFiles, faultsLocation, noticesLocation)
-
-	return err
+	return nil
 }
 
-func backupFault(ctx *Context, fault *hb.Fault, s3Faults *s3.Upload, s3Notices *s3.Upload, faultCount, faultTotal int, lastRunTimestamp int64) error {
+// Backs up a fault's notices then the fault, carrying on from the progress
+// of a checkpoint taken partway through them. pageDone is called between
+// pages of notices
+func backupFault(ctx *Context, checkpoint *Checkpoint, fault *hb.Fault, faultArchived bool, progress *FaultProgress, s3Faults storage.Upload, s3Notices storage.Upload, faultCount, faultTotal int, pageDone func() error) error {
 	// Get the projects faults
-	notices := hb.NewNotices(fault.ProjectId, fault.Id, ctx.HoneybadgerKey, lastRunTimestamp)
+	notices := hb.NewNotices(ctx.HoneybadgerEndpoint, fault.ProjectId, fault.Id, ctx.HoneybadgerKey, checkpoint.notices.After())
+	if progress.Notices != nil {
+		notices.Seek(*progress.Notices)
+	}
+	notices.PageDone = func() error {
+		cursor := notices.Cursor()
+		progress.Notices = &cursor
+		return pageDone()
+	}
 
-	noticeCount := 0
 	for notice, more := notices.Next(); more; notice, more = notices.Next() {
-		noticeCount++
+		progress.NoticeCount++
+		noticeCount := progress.NoticeCount
 		if notices.TotalCount < 150 || noticeCount%100 == 0 {
 			log.WithFields(
 				log.Fields{
@@ -146,42 +471,159 @@ func backupFault(ctx *Context, fault *hb.Fault, s3Faults *s3.Upload, s3Notices *
 					"notice total": notices.TotalCount},
 			).Info("Notices")
 		}
+		// Notices in the overlap the previous run already archived
+		id := strconv.Itoa(notice.Id)
+		checkpoint.notices.Add(id, notice.Timestamp())
+		if checkpoint.notices.Archived(id) {
+			checkpoint.skipped++
+			continue
+		}
+		// Notices archived by any earlier run, e.g. one started from --last-run
+		indexed, err := checkpoint.index.Contains(fault.Id, notice.Id, notice.Timestamp())
+		if err != nil {
+			return err
+		}
+		if indexed {
+			checkpoint.duplicates++
+			continue
+		}
 		// Upload this notice
-		err := s3Notices.Upload(notice)
+		err = s3Notices.Upload(archived(ctx, notice, notice.Raw))
 		if err != nil {
-			s3Notices.HandleError(err)
 			return err
 		}
+		if err = checkpoint.index.Add(fault.Id, notice.Id, notice.Timestamp()); err != nil {
+			return err
+		}
+		progress.Uploaded++
 	}
-	// Upload this fault
-	err := s3Faults.Upload(fault)
-	if err != nil {
-		s3Faults.HandleError(err)
+	if err := notices.Err(); err != nil {
 		return err
 	}
+	if faultArchived && progress.Uploaded == 0 {
+		checkpoint.skipped++
+		return nil
+	}
+	// Upload this fault
+	return s3Faults.Upload(archived(ctx, fault, fault.Raw))
+}
 
-	return err
+func noticeIndexPrefix(ctx *Context, projectId int) string {
+	return storage.JoinKey(ctx.S3prefix, NOTICE_INDEX_DIR, strconv.Itoa(projectId))
 }
 
-func projectProgressBarTotal(projects *hb.Projects) int {
-	if projects.IncludeAll {
-		return projects.TotalCount
-	} else {
-		return len(projects.ProjectIncludeList)
+// The index of the notices archived of a project, along with those an
+// interrupted run archived before its checkpoint. Each day of the index is
+// read when notices from it are looked up
+func loadNoticeIndex(ctx *Context, projectId int, checkpoint *Checkpoint) (*storage.DailyNoticeIndex, error) {
+	index := storage.NewDailyNoticeIndex(ctx.Store, noticeIndexPrefix(ctx, projectId))
+	if checkpoint != nil {
+		if err := index.Merge(checkpointIndexPrefix(ctx, projectId)); err != nil {
+			return nil, err
+		}
 	}
+	return index, nil
 }
 
-func constructS3FilePath(s3Prefix string, names ...string) string {
-	t := time.Now()
-	var parsedNames []string
-	for _, v := range names {
-		parsedNames = append(parsedNames, strings.Replace(v, " ", "_", -1))
+// Returns what to archive for a record. In raw mode that's the JSON the API
+// returned, including any fields the typed record doesn't declare
+func archived(ctx *Context, record storage.Timestamped, raw json.RawMessage) interface{} {
+	if ctx.Raw && len(raw) > 0 {
+		return storage.RawRecord{JSON: raw, Time: record.Timestamp()}
 	}
-	parsedNames = append(parsedNames, t.Format("20060102150405"))
-	suffix := strings.Join(parsedNames, "-") + ".json"
-	if len(s3Prefix) < 1 {
-		return suffix
-	} else {
-		return s3Prefix + "/" + suffix
+	return record
+}
+
+// Creates the upload for a type of record, e.g. the notices of a project. The
+// projects themselves have no project
+func newUpload(ctx *Context, recordType string, project *hb.Project) storage.Upload {
+	vars := keyVars(ctx, recordType, project)
+	if ctx.KeyTemplate.Partitioned() {
+		upload := storage.NewPartitionedUpload(ctx.Store, partitionKey(ctx, vars), ctx.RunStart)
+		upload.OnClose = recordClosed(ctx, recordType, project)
+		return upload
+	}
+	return ctx.Store.NewUpload(ctx.KeyTemplate.Key(vars, ctx.RunStart))
+}
+
+// Carries on the upload of a type of record from its checkpoint. New
+// partitions are named by this run
+func resumeUpload(ctx *Context, recordType string, project *hb.Project, state *storage.UploadState) (storage.Upload, error) {
+	if state.Partitioned {
+		upload, err := storage.ResumePartitionedUpload(ctx.Store, partitionKey(ctx, keyVars(ctx, recordType, project)), ctx.RunStart, state)
+		if err != nil {
+			return nil, err
+		}
+		upload.OnClose = recordClosed(ctx, recordType, project)
+		return upload, nil
+	}
+	return ctx.Store.ResumeUpload(state)
+}
+
+// Lists the objects of partitions closed early as pending in the manifest, and
+// saves it straight away, so they're recorded even if the run dies before the
+// rest of their uploads are done
+func recordClosed(ctx *Context, recordType string, project *hb.Project) func([]storage.Completed) error {
+	name, id := "", 0
+	if project != nil {
+		name, id = project.Name, project.Id
+	}
+	return func(completed []storage.Completed) error {
+		ctx.Manifest.AddPending(name, id, recordType, completed)
+		return ctx.Manifest.Save(ctx.Store, storage.ManifestKey(ctx.S3prefix, ctx.RunStart))
+	}
+}
+
+func keyVars(ctx *Context, recordType string, project *hb.Project) storage.KeyVars {
+	vars := storage.KeyVars{
+		Prefix:      ctx.S3prefix,
+		Environment: ctx.Environment,
+		Type:        recordType,
+		RunStart:    ctx.RunStart,
+		Extension:   ctx.Output.Extension(),
+	}
+	if project != nil {
+		vars.Project = project.Name
+		vars.ProjectId = project.Id
+	}
+	return vars
+}
+
+// A partition started again after it was closed is written to a new object,
+// numbered before the extension e.g. .../20240101120000-1.json.gz
+func partitionKey(ctx *Context, vars storage.KeyVars) storage.PartitionKey {
+	return func(t time.Time, object int) string {
+		key := ctx.KeyTemplate.Key(vars, t)
+		if object < 1 {
+			return key
+		}
+		suffix := "-" + strconv.Itoa(object)
+		if len(vars.Extension) > 0 && strings.HasSuffix(key, vars.Extension) {
+			return strings.TrimSuffix(key, vars.Extension) + suffix + vars.Extension
+		}
+		return key + suffix
+	}
+}
+
+// Opens the store to back up to. The destination is a URL such as
+// s3://bucket or file:///var/backups/honeybadger. When no destination is given
+// the S3 bucket is used
+func openStore(ctx *Context) (storage.Store, error) {
+	if len(ctx.Destination) < 1 {
+		return s3.NewStore(ctx.S3bucket, ctx.Output), nil
+	}
+	u, err := url.Parse(ctx.Destination)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		if prefix := strings.Trim(u.Path, "/"); len(prefix) > 0 && len(ctx.S3prefix) < 1 {
+			ctx.S3prefix = prefix
+		}
+		return s3.NewStore(u.Host, ctx.Output), nil
+	case "file":
+		return file.NewStore(u.Path, ctx.Output), nil
 	}
+	return nil, fmt.Errorf("unsupported destination %q, expected an s3:// or file:// URL", ctx.Destination)
 }
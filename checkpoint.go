@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// The directory under the prefix of the checkpoints of projects being backed up
+const CHECKPOINT_DIR = "checkpoints"
+
+// How often a project's progress is checkpointed, unless configured
+const DEFAULT_CHECKPOINT_INTERVAL = time.Minute
+
+// Checkpoint is how far a run got backing up a project, so that if the run is
+// interrupted the next run can carry on the same uploads instead of starting
+// the project over. Checkpoints are taken between pages of faults, and between
+// pages of the notices of a fault
+type Checkpoint struct {
+	RunId        string               `json:"run_id"`
+	ProjectId    int                  `json:"project_id"`
+	Project      string               `json:"project"`
+	Prev         storage.ProjectState `json:"prev"` // Where the backup of the project started from
+	Next         storage.ProjectState `json:"next"` // Where the next backup of the project starts from
+	Faults       *hb.Cursor           `json:"faults,omitempty"`
+	Fault        *FaultProgress       `json:"fault,omitempty"` // The fault whose notices were being backed up, if any
+	FaultCount   int                  `json:"fault_count"`
+	FaultUpload  *storage.UploadState `json:"fault_upload,omitempty"`
+	NoticeUpload *storage.UploadState `json:"notice_upload,omitempty"`
+	SavedAt      time.Time            `json:"saved_at"`
+
+	faults     *storage.Watermark
+	notices    *storage.Watermark
+	skipped    int                       // Records skipped as the previous run archived them
+	index      *storage.DailyNoticeIndex // The notices archived of the project
+	duplicates int                       // Notices skipped as the index has them
+	pending    map[string]bool           // The objects of pending upload bytes the last checkpoint saved
+	stopped    bool                      // Saved as the run was stopped, so the uploads are left to be resumed
+}
+
+// FaultProgress is how far the backup of a fault's notices got
+type FaultProgress struct {
+	Id          int        `json:"id"`
+	Page        hb.Cursor  `json:"page"`              // The page of faults the fault is on
+	Done        []int      `json:"done,omitempty"`    // The faults on the page already backed up
+	Notices     *hb.Cursor `json:"notices,omitempty"` // Where the fault's notices got to
+	NoticeCount int        `json:"notice_count"`
+	Uploaded    int        `json:"uploaded"` // How many of the fault's notices were archived
+}
+
+// Start tracking the watermarks of the project from where the backup started,
+// and where it had got to if it's being resumed
+func (c *Checkpoint) track(overlap time.Duration) {
+	c.faults = storage.NewWatermark(c.Prev.Faults, c.Prev.FaultsSeen, overlap)
+	c.faults.Resume(c.Next.Faults, c.Next.FaultsSeen)
+	c.notices = storage.NewWatermark(c.Prev.Notices, c.Prev.NoticesSeen, overlap)
+	c.notices.Resume(c.Next.Notices, c.Next.NoticesSeen)
+}
+
+// Where the next backup of the project starts from, given the records seen so
+// far
+func (c *Checkpoint) next() storage.ProjectState {
+	next := storage.ProjectState{Name: c.Project}
+	next.Faults, next.FaultsSeen = c.faults.Next()
+	next.Notices, next.NoticesSeen = c.notices.Next()
+	return next
+}
+
+func checkpointKey(ctx *Context, projectId int) string {
+	return storage.JoinKey(ctx.S3prefix, CHECKPOINT_DIR, strconv.Itoa(projectId)+".json")
+}
+
+// The directory of the files a project's checkpoint refers to
+func checkpointDir(ctx *Context, projectId int) string {
+	return storage.JoinKey(ctx.S3prefix, CHECKPOINT_DIR, strconv.Itoa(projectId))
+}
+
+// The notices archived before the checkpoint, a file per day, which aren't in
+// the project's notice index until the project's done
+func checkpointIndexPrefix(ctx *Context, projectId int) string {
+	return checkpointDir(ctx, projectId) + "/index"
+}
+
+// The bytes written to uploads but not yet uploaded, which are too big to
+// rewrite inside the checkpoint every time
+func checkpointPendingPrefix(ctx *Context, projectId int) string {
+	return checkpointDir(ctx, projectId) + "/pending"
+}
+
+// Read the checkpoints left by an interrupted run, keyed by project ID
+func loadCheckpoints(ctx *Context) (map[int]*Checkpoint, error) {
+	checkpoints := map[int]*Checkpoint{}
+	objects, err := ctx.Store.List(storage.JoinKey(ctx.S3prefix, CHECKPOINT_DIR) + "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, ".json") {
+			continue
+		}
+		body, err := ctx.Store.Read(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		c := &Checkpoint{}
+		if err := json.Unmarshal(b, c); err != nil {
+			log.WithFields(log.Fields{"checkpoint": object.Key}).Warn(err)
+			continue
+		}
+		checkpoints[c.ProjectId] = c
+	}
+	return checkpoints, nil
+}
+
+// The keys of the uploads the checkpoints carry on, which mustn't be cleaned up
+func checkpointedKeys(checkpoints map[int]*Checkpoint) map[string]bool {
+	keys := map[string]bool{}
+	for _, c := range checkpoints {
+		for _, state := range []*storage.UploadState{c.FaultUpload, c.NoticeUpload} {
+			if state == nil {
+				continue
+			}
+			for _, key := range state.Keys() {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// Checkpoint both uploads of a project and save where the faults got to
+func saveCheckpoint(ctx *Context, c *Checkpoint, cursor hb.Cursor, s3Faults, s3Notices storage.Upload) error {
+	faults, err := s3Faults.Checkpoint()
+	if err != nil {
+		return err
+	}
+	notices, err := s3Notices.Checkpoint()
+	if err != nil {
+		return err
+	}
+	// Save the notices archived so far first, as the checkpoint can't be
+	// resumed without them
+	if err := c.index.SaveAdded(checkpointIndexPrefix(ctx, c.ProjectId)); err != nil {
+		return err
+	}
+	pending := map[string]bool{}
+	for _, state := range []*storage.UploadState{faults, notices} {
+		keys, err := state.SavePending(ctx.Store, checkpointPendingPrefix(ctx, c.ProjectId), c.pending)
+		if err != nil {
+			return err
+		}
+		for key := range keys {
+			pending[key] = true
+		}
+	}
+	c.Faults, c.FaultUpload, c.NoticeUpload, c.SavedAt = &cursor, faults, notices, time.Now()
+	c.Next = c.next()
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// Remember to delete the checkpoint once the project's done, even if this
+	// fails as it may have been partly written
+	ctx.Checkpoints[c.ProjectId] = c
+	if err := ctx.Store.Put(checkpointKey(ctx, c.ProjectId), body); err != nil {
+		for key := range c.pending {
+			pending[key] = true
+		}
+		c.pending = pending
+		return err
+	}
+	// The pending bytes only the previous checkpoint refers to
+	for key := range c.pending {
+		if !pending[key] {
+			if err := ctx.Store.Delete(key); err != nil {
+				log.WithFields(log.Fields{"checkpoint": key}).Warn(err)
+			}
+		}
+	}
+	c.pending = pending
+	return nil
+}
+
+func deleteCheckpoint(ctx *Context, projectId int) {
+	delete(ctx.Checkpoints, projectId)
+	keys := []string{checkpointKey(ctx, projectId)}
+	objects, err := ctx.Store.List(checkpointDir(ctx, projectId) + "/")
+	if err != nil {
+		log.WithFields(log.Fields{"checkpoint": checkpointDir(ctx, projectId)}).Warn(err)
+	}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	for _, key := range keys {
+		if err := ctx.Store.Delete(key); err != nil {
+			log.WithFields(log.Fields{"checkpoint": key}).Warn(err)
+		}
+	}
+}
+
+// Carry on the uploads of a project from its checkpoint
+func resumeUploads(ctx *Context, project *hb.Project, c *Checkpoint) (storage.Upload, storage.Upload, error) {
+	if c.FaultUpload == nil || c.NoticeUpload == nil {
+		return nil, nil, storage.ErrNotResumable
+	}
+	for _, state := range []*storage.UploadState{c.FaultUpload, c.NoticeUpload} {
+		if err := state.ReadPending(ctx.Store); err != nil {
+			return nil, nil, err
+		}
+	}
+	s3Faults, err := resumeUpload(ctx, "faults", project, c.FaultUpload)
+	if err != nil {
+		return nil, nil, err
+	}
+	s3Notices, err := resumeUpload(ctx, "notices", project, c.NoticeUpload)
+	if err != nil {
+		s3Faults.AbortUpload()
+		return nil, nil, err
+	}
+	return s3Faults, s3Notices, nil
+}
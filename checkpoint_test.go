@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+func TestCheckpointsResumeWithoutAPrefix(t *testing.T) {
+	store := newFileStore(t)
+	ctx := &Context{Store: store, Checkpoints: map[int]*Checkpoint{}}
+	checkpoint := &Checkpoint{RunId: "20240101120000", ProjectId: 1, Project: "Mindful"}
+	checkpoint.track(time.Hour)
+	checkpoint.index = storage.NewDailyNoticeIndex(store, noticeIndexPrefix(ctx, 1))
+	if err := checkpoint.index.Add(2, 3, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	var uploads []storage.Upload
+	for _, recordType := range []string{"faults", "notices"} {
+		upload := store.NewUpload(recordType + ".json")
+		if err := upload.CreateUpload(); err != nil {
+			t.Fatal(err)
+		}
+		if err := upload.Upload(map[string]int{"id": 1}); err != nil {
+			t.Fatal(err)
+		}
+		uploads = append(uploads, upload)
+	}
+	if err := saveCheckpoint(ctx, checkpoint, hb.Cursor{NextPage: 2}, uploads[0], uploads[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if strings.HasPrefix(object.Key, "/") {
+			t.Errorf("expected keys without a leading slash but got %s", object.Key)
+		}
+	}
+	loaded, err := loadCheckpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := loaded[1]; c == nil || c.RunId != checkpoint.RunId {
+		t.Fatalf("expected the checkpoint of project 1 to be loaded but got %+v", loaded)
+	}
+
+	deleteCheckpoint(ctx, 1)
+	if objects, _ := store.List(CHECKPOINT_DIR + "/"); len(objects) > 0 {
+		t.Errorf("expected the checkpoint and what it refers to to be deleted but got %v", objects)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Job is a backup of one Honeybadger account to one destination. The config
+// file declares any number of them, otherwise the flags make up a single job
+type Job struct {
+	Name                 string        `yaml:"name"`
+	S3Bucket             string        `yaml:"s3_bucket"`
+	S3Directory          string        `yaml:"s3_directory"`
+	S3Region             string        `yaml:"s3_region"`
+	S3Endpoint           string        `yaml:"s3_endpoint"`
+	S3ForcePathStyle     bool          `yaml:"s3_force_path_style"`
+	S3InsecureSkipVerify bool          `yaml:"s3_insecure_skip_verify"`
+	Destination          string        `yaml:"destination"`
+	Projects             []string      `yaml:"projects"`
+	HoneybadgerKey       string        `yaml:"honeybadger_key"`
+	HoneybadgerRegion    string        `yaml:"honeybadger_region"`
+	HoneybadgerEndpoint  string        `yaml:"honeybadger_endpoint"`
+	Raw                  bool          `yaml:"raw"`
+	Format               string        `yaml:"format"`
+	Compression          string        `yaml:"compression"`
+	ParquetRowGroupSize  int           `yaml:"parquet_row_group_size"`
+	Layout               string        `yaml:"layout"`
+	KeyTemplate          string        `yaml:"key_template"`
+	Environment          string        `yaml:"environment"`
+	LockLease            time.Duration `yaml:"lock_lease"`
+	WatermarkOverlap     time.Duration `yaml:"watermark_overlap"`
+	CheckpointInterval   time.Duration `yaml:"checkpoint_interval"`
+	LastRun              string        `yaml:"last_run"`
+	Schedule             string        `yaml:"schedule"` // When the daemon runs the job
+	Jitter               time.Duration `yaml:"jitter"`   // The most the daemon delays each run by, at random
+}
+
+// Jobs from the flags have no name
+func jobName(job Job) string {
+	if len(job.Name) < 1 {
+		return "backup"
+	}
+	return job.Name
+}
+
+// The config file. Defaults apply to every job, and a job sets only what
+// differs from them
+type config struct {
+	Defaults yaml.MapSlice   `yaml:"defaults"`
+	Jobs     []yaml.MapSlice `yaml:"jobs"`
+}
+
+// A reference to an environment variable in the config file
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replaces each ${VAR} with the environment variable, failing if any aren't
+// set. Anything else, e.g. a $ in a key, is left as it is
+func expandEnv(s string) (string, error) {
+	var unset []string
+	expanded := envVar.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envVar.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		return value
+	})
+	if len(unset) > 0 {
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(unset, ", "))
+	}
+	return expanded, nil
+}
+
+// Replaces ${VAR} in each text value of settings, including those in lists.
+// Values are expanded after the YAML is parsed, so whatever a variable holds
+// is only ever the value, and references in comments and keys are left alone
+func expandSettings(settings yaml.MapSlice) error {
+	for i := range settings {
+		value, err := expandValue(settings[i].Value)
+		if err != nil {
+			return fmt.Errorf("%v: %v", settings[i].Key, err)
+		}
+		settings[i].Value = value
+	}
+	return nil
+}
+
+func expandValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return expandEnv(value)
+	case []interface{}:
+		for i := range value {
+			expanded, err := expandValue(value[i])
+			if err != nil {
+				return nil, err
+			}
+			value[i] = expanded
+		}
+		return value, nil
+	case yaml.MapSlice:
+		return value, expandSettings(value)
+	}
+	return value, nil
+}
+
+// Reads the jobs in the config file at path. Each job starts from base, the
+// flags, then the file's defaults, then its own settings. ${VAR} is replaced
+// by the environment variable, so API keys needn't be in the file
+func loadJobs(path string, base Job) ([]Job, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := config{}
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("reading config %s: %v", path, err)
+	}
+	if len(c.Jobs) < 1 {
+		return nil, fmt.Errorf("config %s has no jobs", path)
+	}
+	if err := expandSettings(c.Defaults); err != nil {
+		return nil, fmt.Errorf("reading config %s defaults: %v", path, err)
+	}
+	for i, settings := range c.Jobs {
+		if err := expandSettings(settings); err != nil {
+			return nil, fmt.Errorf("reading config %s job %d: %v", path, i+1, err)
+		}
+	}
+	if err := overlay(&base, c.Defaults); err != nil {
+		return nil, fmt.Errorf("reading config %s defaults: %v", path, err)
+	}
+	base.Name = ""
+	jobs := make([]Job, len(c.Jobs))
+	names := map[string]bool{}
+	for i, settings := range c.Jobs {
+		jobs[i] = base
+		if err := overlay(&jobs[i], settings); err != nil {
+			return nil, fmt.Errorf("reading config %s job %d: %v", path, i+1, err)
+		}
+		if len(jobs[i].Name) < 1 {
+			jobs[i].Name = fmt.Sprintf("job-%d", i+1)
+		}
+		if names[jobs[i].Name] {
+			return nil, fmt.Errorf("config %s has more than one job named %q", path, jobs[i].Name)
+		}
+		names[jobs[i].Name] = true
+	}
+	return jobs, nil
+}
+
+// Sets the fields of a job that are in settings, leaving the rest as they are
+func overlay(job *Job, settings yaml.MapSlice) error {
+	if len(settings) < 1 {
+		return nil
+	}
+	b, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(b, job)
+}
+
+// The jobs with the given comma separated names, or every job when there are
+// no names
+func selectJobs(jobs []Job, names string) ([]Job, error) {
+	if len(strings.TrimSpace(names)) < 1 {
+		return jobs, nil
+	}
+	byName := map[string]Job{}
+	for _, job := range jobs {
+		byName[job.Name] = job
+	}
+	var selected []Job
+	for _, name := range strings.Split(names, ",") {
+		job, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("no job named %q in the config", strings.TrimSpace(name))
+		}
+		selected = append(selected, job)
+	}
+	return selected, nil
+}
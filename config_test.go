@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a config file, returning its path and a func to remove it
+func writeConfig(t *testing.T, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "honeybadger-s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestJobsStartFromTheFlagsThenTheDefaults(t *testing.T) {
+	path, done := writeConfig(t, `
+defaults:
+  s3_bucket: defaults-bucket
+  format: ndjson
+jobs:
+  - name: us
+  - name: eu
+    s3_bucket: eu-bucket
+    lock_lease: 5m
+`)
+	defer done()
+	flags := Job{S3Bucket: "flags-bucket", S3Directory: "flags-directory", Format: "json", LockLease: time.Minute}
+	jobs, err := loadJobs(path, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs but got %d", len(jobs))
+	}
+	us, eu := jobs[0], jobs[1]
+	if us.S3Bucket != "defaults-bucket" || us.S3Directory != "flags-directory" || us.Format != "ndjson" || us.LockLease != time.Minute {
+		t.Errorf("expected the us job to take the defaults over the flags but got %+v", us)
+	}
+	if eu.S3Bucket != "eu-bucket" || eu.S3Directory != "flags-directory" || eu.Format != "ndjson" || eu.LockLease != 5*time.Minute {
+		t.Errorf("expected the eu job to take its own settings over the defaults but got %+v", eu)
+	}
+}
+
+func TestJobsNeedDifferentNames(t *testing.T) {
+	path, done := writeConfig(t, `
+jobs:
+  - name: us
+  - name: us
+`)
+	defer done()
+	if _, err := loadJobs(path, Job{}); err == nil || !strings.Contains(err.Error(), `more than one job named "us"`) {
+		t.Errorf("expected an error for the duplicate name but got %v", err)
+	}
+	path, done = writeConfig(t, `
+jobs:
+  - s3_bucket: first
+  - s3_bucket: second
+`)
+	defer done()
+	jobs, err := loadJobs(path, Job{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Name != "job-1" || jobs[1].Name != "job-2" {
+		t.Errorf("expected unnamed jobs to be numbered but got %s and %s", jobs[0].Name, jobs[1].Name)
+	}
+}
+
+func TestConfigOnlyExpandsBracedVariables(t *testing.T) {
+	os.Setenv("HB_TEST_API_KEY", "secret")
+	defer os.Unsetenv("HB_TEST_API_KEY")
+	path, done := writeConfig(t, `
+jobs:
+  - name: us
+    honeybadger_key: ${HB_TEST_API_KEY}
+    s3_directory: archive$1
+`)
+	defer done()
+	jobs, err := loadJobs(path, Job{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].HoneybadgerKey != "secret" {
+		t.Errorf("expected secret but got %s", jobs[0].HoneybadgerKey)
+	}
+	if jobs[0].S3Directory != "archive$1" {
+		t.Errorf("expected archive$1 but got %s", jobs[0].S3Directory)
+	}
+
+	os.Unsetenv("HB_TEST_API_KEY")
+	if _, err := loadJobs(path, Job{}); err == nil || !strings.Contains(err.Error(), "HB_TEST_API_KEY") {
+		t.Errorf("expected an error naming the unset variable but got %v", err)
+	}
+}
+
+func TestConfigExpandsVariablesInValuesOnly(t *testing.T) {
+	os.Setenv("HB_TEST_DIRECTORY", "archive: \n# not a comment")
+	defer os.Unsetenv("HB_TEST_DIRECTORY")
+	path, done := writeConfig(t, `
+# ${HB_TEST_UNSET} isn't needed in a comment
+jobs:
+  - name: us
+    s3_directory: ${HB_TEST_DIRECTORY}
+    projects:
+      - ${HB_TEST_DIRECTORY}
+`)
+	defer done()
+	jobs, err := loadJobs(path, Job{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].S3Directory != "archive: \n# not a comment" {
+		t.Errorf("expected the variable's value as it is but got %q", jobs[0].S3Directory)
+	}
+	if len(jobs[0].Projects) != 1 || jobs[0].Projects[0] != "archive: \n# not a comment" {
+		t.Errorf("expected the variable's value as the one project but got %q", jobs[0].Projects)
+	}
+}
+
+func TestSelectJobs(t *testing.T) {
+	jobs := []Job{{Name: "us"}, {Name: "eu"}}
+	selected, err := selectJobs(jobs, "eu, us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Name != "eu" || selected[1].Name != "us" {
+		t.Errorf("expected eu then us but got %+v", selected)
+	}
+	if selected, _ := selectJobs(jobs, ""); len(selected) != 2 {
+		t.Errorf("expected every job without names but got %+v", selected)
+	}
+	if _, err := selectJobs(jobs, "us,apac"); err == nil || !strings.Contains(err.Error(), `"apac"`) {
+		t.Errorf("expected an error naming the unknown job but got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/robfig/cron"
+)
+
+// Parses when a job runs, either a cron expression e.g. "*/15 * * * *", a
+// descriptor e.g. "@hourly" or "@every 15m", or just an interval e.g. "15m"
+func parseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) < 1 {
+		return nil, errors.New("schedule argument is required!")
+	}
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("schedule %q must be a positive interval", spec)
+		}
+		return cron.Every(interval), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q: %v", spec, err)
+	}
+	return schedule, nil
+}
+
+// How the daemon runs
+type daemonOptions struct {
+	RunOnStart      bool   // Run every job as soon as the daemon starts
+	MetricsListen   string // The address to serve metrics on, if any
+	MetricsTextfile string // The node_exporter textfile to write after every run, if any
+}
+
+// A job and when it runs
+type scheduledJob struct {
+	job      Job
+	schedule cron.Schedule
+}
+
+// The lock of the run in progress, so the daemon can release it if it's
+// stopped before the run is
+type heldLock struct {
+	mu   sync.Mutex
+	lock *storage.Lock
+}
+
+func (h *heldLock) set(lock *storage.Lock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lock = lock
+}
+
+func (h *heldLock) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lock == nil {
+		return
+	}
+	if err := h.lock.Release(); err != nil {
+		log.WithFields(log.Fields{"lock": h.lock.Key}).Error(err)
+	}
+}
+
+// Runs the jobs on their schedules until the process is sent SIGINT or
+// SIGTERM. Then no more runs start, the run in progress checkpoints and stops
+// at its next page, and the daemon returns once it has. A second signal
+// releases the run's lock and returns straight away. Stopped runs carry on
+// from their checkpoints when the daemon is next started.
+//
+// Only one run happens at a time, so the jobs share the S3 client and the
+// connections to Honeybadger. A run that's still going when its job is next
+// due skips that run rather than overlapping it, and a failed run is logged
+// and doesn't stop the job's next run
+func daemon(jobs []Job, options daemonOptions) error {
+	var scheduled []*scheduledJob
+	for _, job := range jobs {
+		if len(job.LastRun) > 0 {
+			return fmt.Errorf("last-run can't be used with daemon, every run of job %s would start from it", jobName(job))
+		}
+		schedule, err := parseSchedule(job.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", jobName(job), err)
+		}
+		scheduled = append(scheduled, &scheduledJob{job: job, schedule: schedule})
+	}
+
+	if len(options.MetricsListen) > 0 {
+		listener, err := net.Listen("tcp", options.MetricsListen)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		server := &http.Server{Handler: mux}
+		go server.Serve(listener)
+		defer server.Close()
+		log.WithFields(log.Fields{"address": listener.Addr().String()}).Info("Serving metrics on /metrics")
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stop := make(chan struct{})
+	var serial sync.Mutex
+	var held heldLock
+	var loops sync.WaitGroup
+	for _, s := range scheduled {
+		loops.Add(1)
+		go func(s *scheduledJob) {
+			defer loops.Done()
+			s.loop(stop, &serial, &held, options)
+		}(s)
+	}
+	log.WithFields(log.Fields{"jobs": len(scheduled)}).Info("Daemon started")
+
+	sig := <-signals
+	log.WithFields(log.Fields{"signal": sig}).Info("Shutting down once the run in progress stops")
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		loops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("Daemon stopped")
+		return nil
+	case sig = <-signals:
+		held.release()
+		return fmt.Errorf("stopped by a second %v before the run in progress stopped", sig)
+	}
+}
+
+// Runs the job each time it's due until stop is closed
+func (s *scheduledJob) loop(stop <-chan struct{}, serial *sync.Mutex, held *heldLock, options daemonOptions) {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	next := time.Now()
+	if !options.RunOnStart {
+		next = s.schedule.Next(next)
+	}
+	for {
+		// Jitter spreads out the runs of jobs due at the same time, and of
+		// daemons backing up the same accounts
+		if s.job.Jitter > 0 {
+			next = next.Add(time.Duration(random.Int63n(int64(s.job.Jitter))))
+		}
+		log.WithFields(log.Fields{"job": jobName(s.job), "next run": next.Format(time.RFC3339)}).Info("Scheduled")
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		serial.Lock()
+		// The daemon may have been stopped while another job was running
+		select {
+		case <-stop:
+			serial.Unlock()
+			return
+		default:
+		}
+		started := time.Now()
+		runScheduledJob(s.job, stop, held)
+		writeMetricsTextfile(options.MetricsTextfile)
+		serial.Unlock()
+
+		// Runs that were due while this one was going are skipped
+		now := time.Now()
+		next = s.schedule.Next(now)
+		if missed := s.schedule.Next(started); missed.Before(now) {
+			log.WithFields(log.Fields{"job": jobName(s.job), "due": missed}).Warn("Skipped a run that was due while the previous run was still going")
+		}
+	}
+}
+
+// Backs up a job, logging rather than returning its failure so the next run
+// still happens. The run stops when stop is closed, and its lock is held by
+// held while it runs
+func runScheduledJob(job Job, stop <-chan struct{}, held *heldLock) {
+	started := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{"job": jobName(job), "panic": r}).Error("Run failed")
+			recordRun(jobName(job), nil, started, fmt.Errorf("panic: %v", r))
+		}
+	}()
+	log.WithFields(log.Fields{"job": jobName(job)}).Info("Run started")
+	defer held.set(nil)
+	err := backupJob(job, func(ctx *Context) {
+		ctx.Stop, ctx.Locked = stop, held.set
+	})
+	if err == ErrStopped {
+		log.WithFields(log.Fields{"job": jobName(job), "duration": time.Since(started)}).Info("Run stopped, the next run carries on from where it got to")
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"job": jobName(job), "duration": time.Since(started)}).Error("Run failed: ", err)
+		return
+	}
+	log.WithFields(log.Fields{"job": jobName(job), "duration": time.Since(started)}).Info("Run finished")
+}
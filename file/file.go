@@ -0,0 +1,346 @@
+package file
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Suffix of files that are still being written. They're renamed to their
+// final key once the upload completes
+const PARTIAL_SUFFIX = ".partial"
+
+// Store backs up to a directory on the local filesystem, e.g. a local disk or
+// an NFS mount. Keys are paths relative to the Root directory
+type Store struct {
+	Root   string
+	Output storage.Output // How records are written to uploads
+}
+
+type Upload struct {
+	Key     string
+	Path    string
+	HasData bool // Did we call Upload() at least once
+	Output  storage.Output
+	file    *os.File
+	writer  *bufio.Writer
+	records *storage.Encoder
+}
+
+func NewStore(root string, out storage.Output) *Store {
+	return &Store{Root: root, Output: out}
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+func (s *Store) NewUpload(key string) storage.Upload {
+	upload := NewUpload(s.path(key), s.Output)
+	upload.Key = key
+	return upload
+}
+
+func (s *Store) ResumeUpload(state *storage.UploadState) (storage.Upload, error) {
+	upload, err := ResumeUpload(s.path(state.Key), s.Output, state)
+	if err != nil {
+		return nil, err
+	}
+	upload.Key = state.Key
+	return upload, nil
+}
+
+func (s *Store) Read(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrNotExist
+	}
+	return f, err
+}
+
+// Read the records in the file at key
+func (s *Store) Records(key string) (*storage.RecordReader, error) {
+	body, err := s.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewRecordReader(body)
+}
+
+// Writes the file next to its final path first and then renames it, so a
+// reader never sees a partially written file
+func (s *Store) Put(key string, body []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+PARTIAL_SUFFIX, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+PARTIAL_SUFFIX, path)
+}
+
+// Copies body to the file next to its final path and then renames it, once
+// verify accepts what was copied
+func (s *Store) PutStream(key string, body io.Reader, verify func() error) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path + PARTIAL_SUFFIX)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(path + PARTIAL_SUFFIX)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path + PARTIAL_SUFFIX)
+		return err
+	}
+	if verify != nil {
+		if err := verify(); err != nil {
+			os.Remove(path + PARTIAL_SUFFIX)
+			return err
+		}
+	}
+	return os.Rename(path+PARTIAL_SUFFIX, path)
+}
+
+// The version of a file is the SHA-256 digest of its contents
+func (s *Store) ReadVersion(key string) ([]byte, string, error) {
+	body, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, "", storage.ErrNotExist
+	}
+	return body, version(body), err
+}
+
+// Creates the file with O_EXCL, so only one writer can create it
+func (s *Store) PutIfNoneMatch(key string, body []byte) (string, error) {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return "", storage.ErrPreconditionFailed
+	} else if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return "", err
+	}
+	return version(body), f.Close()
+}
+
+// Checks the file is still at version before replacing it. The check and
+// the replace aren't atomic, so two writers racing within that window can both
+// succeed, unlike S3
+func (s *Store) PutIfMatch(key string, body []byte, expected string) (string, error) {
+	_, current, err := s.ReadVersion(key)
+	if err == storage.ErrNotExist {
+		return "", storage.ErrPreconditionFailed
+	} else if err != nil {
+		return "", err
+	}
+	if current != expected {
+		return "", storage.ErrPreconditionFailed
+	}
+	return version(body), s.Put(key, body)
+}
+
+func version(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) List(prefix string) ([]storage.Object, error) {
+	var objects []storage.Object
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, PARTIAL_SUFFIX) {
+			return nil
+		}
+		key, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return objects, nil
+	}
+	return objects, err
+}
+
+func (s *Store) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Remove any partial files left behind by a previous run, except those of the
+// keys in keep
+func (s *Store) CleanUpFailedUploads(prefix string, keep map[string]bool) {
+	cleanedCount := 0
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		key, _ := filepath.Rel(s.Root, path)
+		key = filepath.ToSlash(key)
+		if !info.IsDir() && strings.HasSuffix(key, PARTIAL_SUFFIX) && strings.HasPrefix(key, prefix) && !keep[strings.TrimSuffix(key, PARTIAL_SUFFIX)] {
+			cleanedCount++
+			if err := os.Remove(path); err != nil {
+				log.WithFields(log.Fields{"path": path}).Error(err)
+			}
+		}
+		return nil
+	})
+
+	if err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"root":   s.Root,
+			"prefix": prefix,
+		}).Error(err)
+	}
+	log.WithFields(log.Fields{
+		"count": cleanedCount,
+	}).Info("Cleaned up failed uploads")
+}
+
+func NewUpload(path string, out storage.Output) *Upload {
+	return &Upload{Path: path, Output: out}
+}
+
+// Create the partial file that records are written to
+func (p *Upload) CreateUpload() error {
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p.Path + PARTIAL_SUFFIX)
+	if err != nil {
+		return err
+	}
+	p.file = f
+	p.writer = bufio.NewWriter(f)
+	p.records, err = storage.NewEncoder(p.writer, p.Output)
+	return err
+}
+
+// Carry on writing the partial file from its last checkpoint. Anything written
+// to it after the checkpoint is cut off
+func ResumeUpload(path string, out storage.Output, state *storage.UploadState) (*Upload, error) {
+	p := NewUpload(path, out)
+	p.HasData = state.HasData
+	f, err := os.OpenFile(path+PARTIAL_SUFFIX, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(state.Encoder.Bytes); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	p.file = f
+	p.writer = bufio.NewWriter(f)
+	p.records, err = storage.ResumeEncoder(p.writer, out, state.Encoder)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Save a honeybadger record to the partial file
+func (p *Upload) Upload(hbRecord interface{}) error {
+	p.HasData = true
+	return p.records.Write(hbRecord)
+}
+
+// Flush everything written so far to the partial file
+func (p *Upload) Checkpoint() (*storage.UploadState, error) {
+	encoder, err := p.records.Checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := p.file.Sync(); err != nil {
+		return nil, err
+	}
+	return &storage.UploadState{Key: p.Key, HasData: p.HasData, Encoder: encoder}, nil
+}
+
+// Move the partial file to its final path if there is at least one record in
+// it, returning the file written. Otherwise the partial file is removed
+func (p *Upload) CompleteUpload() ([]storage.Completed, error) {
+	if !p.HasData {
+		p.AbortUpload()
+		return nil, nil
+	}
+	if err := p.records.Close(); err != nil {
+		return nil, err
+	}
+	if err := p.writer.Flush(); err != nil {
+		return nil, err
+	}
+	if err := p.file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(p.Path+PARTIAL_SUFFIX, p.Path); err != nil {
+		return nil, err
+	}
+	completed := p.records.Stats()
+	completed.Key = p.Key
+	completed.Location = p.FileLocation()
+	return []storage.Completed{completed}, nil
+}
+
+// Close and remove the partial file
+func (p *Upload) AbortUpload() {
+	if p.records != nil {
+		p.records.Close()
+	}
+	if p.file != nil {
+		p.file.Close()
+	}
+	if err := os.Remove(p.Path + PARTIAL_SUFFIX); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{"path": p.Path}).Error(err)
+	}
+}
+
+func (p *Upload) HandleError(err error) {
+	log.WithFields(log.Fields{
+		"path": p.Path,
+	}).Error(err)
+	p.AbortUpload()
+}
+
+func (p *Upload) FileLocation() string {
+	return p.Path
+}
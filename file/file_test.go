@@ -0,0 +1,186 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+// A store in a directory that's removed once the test is done
+func newTestStore(t *testing.T, out storage.Output) *Store {
+	return NewStore(t.TempDir(), out)
+}
+
+func TestUploadOnlyVisibleOnceComplete(t *testing.T) {
+	store := newTestStore(t, storage.Output{Format: storage.FormatNDJSON})
+
+	upload := store.NewUpload("backups/faults.json")
+	if err := upload.CreateUpload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Upload(map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read("backups/faults.json"); err != storage.ErrNotExist {
+		t.Errorf("expected ErrNotExist before completing the upload but got %v", err)
+	}
+	if _, err := upload.CompleteUpload(); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := store.List("backups/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "backups/faults.json" {
+		t.Errorf("expected only backups/faults.json but got %v", objects)
+	}
+}
+
+func TestUploadWithoutDataIsDiscarded(t *testing.T) {
+	store := newTestStore(t, storage.Output{Format: storage.FormatNDJSON})
+
+	upload := store.NewUpload("faults.json")
+	if err := upload.CreateUpload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload.CompleteUpload(); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := store.List(""); len(objects) != 0 {
+		t.Errorf("expected no objects but got %v", objects)
+	}
+}
+
+func TestCompletedUploadStats(t *testing.T) {
+	store := newTestStore(t, storage.Output{Format: storage.FormatNDJSON, Compression: storage.CompressionGzip})
+
+	upload := store.NewUpload("notices.json.gz")
+	if err := upload.CreateUpload(); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{last, first} {
+		if err := upload.Upload(storage.RawRecord{JSON: json.RawMessage(`{"id":1}`), Time: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	completed, err := upload.CompleteUpload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(store.Root, "notices.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body)
+	if len(completed) != 1 {
+		t.Fatalf("expected 1 completed file but got %v", completed)
+	}
+	c := completed[0]
+	if c.Records != 2 || c.Bytes != int64(len(body)) || c.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected 2 records of %d bytes with digest %x but got %+v", len(body), sum, c)
+	}
+	if !c.MinTime.Equal(first) || !c.MaxTime.Equal(last) {
+		t.Errorf("expected records from %v to %v but got %v to %v", first, last, c.MinTime, c.MaxTime)
+	}
+}
+
+func TestLockIsExclusiveUntilReleasedOrExpired(t *testing.T) {
+	store := newTestStore(t, storage.Output{})
+
+	first := storage.NewLock(store, "backups/honeybadger-s3.lock", "1", time.Minute)
+	if err := first.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	second := storage.NewLock(store, "backups/honeybadger-s3.lock", "2", time.Minute)
+	if err := second.Acquire(); err != storage.ErrLocked {
+		t.Fatalf("expected ErrLocked while the first run holds the lock but got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Acquire(); err != nil {
+		t.Fatalf("expected to acquire the released lock but got %v", err)
+	}
+	defer second.Release()
+
+	// A crashed run's lease expires and can be taken over
+	expired, err := json.Marshal(storage.Lease{Owner: "crashed", ExpiresAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("other/honeybadger-s3.lock", expired); err != nil {
+		t.Fatal(err)
+	}
+	third := storage.NewLock(store, "other/honeybadger-s3.lock", "3", time.Minute)
+	if err := third.Acquire(); err != nil {
+		t.Fatalf("expected to take over the expired lock but got %v", err)
+	}
+	third.Release()
+}
+
+func TestResumeUploadFromCheckpoint(t *testing.T) {
+	store := newTestStore(t, storage.Output{Format: storage.FormatJSONArray, Compression: storage.CompressionGzip})
+
+	upload := store.NewUpload("notices.json.gz")
+	if err := upload.CreateUpload(); err != nil {
+		t.Fatal(err)
+	}
+	write := func(upload storage.Upload, ids ...int) {
+		for _, id := range ids {
+			if err := upload.Upload(map[string]int{"id": id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(upload, 1, 2)
+	state, err := upload.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written after the checkpoint, and lost when the run is interrupted
+	write(upload, 3)
+	if _, err := upload.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := store.ResumeUpload(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(resumed, 4)
+	completed, err := resumed.CompleteUpload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(completed) != 1 || completed[0].Records != 3 {
+		t.Fatalf("expected 3 records but got %+v", completed)
+	}
+
+	records, err := store.Records("notices.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for record, err := records.Next(); err == nil; record, err = records.Next() {
+		ids = append(ids, string(record))
+	}
+	if strings.Join(ids, ",") != `{"id":1},{"id":2},{"id":4}` {
+		t.Errorf("expected records 1, 2 and 4 but got %v", ids)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(store.Root, "notices.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(body); completed[0].SHA256 != hex.EncodeToString(sum[:]) || completed[0].Bytes != int64(len(body)) {
+		t.Errorf("expected the digest of the whole file but got %+v", completed[0])
+	}
+}
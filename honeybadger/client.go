@@ -0,0 +1,230 @@
+package honeybadger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	MAX_RETRIES = 5
+	MIN_BACKOFF = time.Duration(1 * time.Second)
+	MAX_BACKOFF = time.Duration(2 * time.Minute)
+)
+
+// Client calls the Honeybadger API. Transport errors, rate limited (429) and
+// server error (5xx) responses are retried with jittered exponential backoff,
+// waiting as long as the API asks through Retry-After and the rate limit
+// headers
+type Client struct {
+	HTTP       *http.Client
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	OnCall     func(status int) // Called after every call with the response status, 0 when there was no response
+	OnRetry    func()           // Called before every retry
+
+	sleep     func(time.Duration)
+	mu        sync.Mutex
+	waitUntil time.Time // Don't call the API before this time
+}
+
+// Returned when the API responds with a status that isn't retried, e.g. 401,
+// or with a retried status once the retries have run out
+type StatusError struct {
+	Url        string
+	StatusCode int
+	Body       string
+}
+
+// Returned when the request still failed after all of its retries
+type RetryError struct {
+	Url      string
+	Attempts int
+	Err      error // The last error, a *StatusError or a transport error
+}
+
+// The client used by CallHB. It is shared so connections are reused
+var DefaultClient = NewClient()
+
+func NewClient() *Client {
+	return &Client{
+		HTTP:       &http.Client{Timeout: HTTP_TIMEOUT},
+		MaxRetries: MAX_RETRIES,
+		MinBackoff: MIN_BACKOFF,
+		MaxBackoff: MAX_BACKOFF,
+		sleep:      time.Sleep,
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("honeybadger responded %d %s to %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Url, e.Body)
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("giving up on %s after %d attempts: %v", e.Url, e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Calls hbUrl and decodes the JSON response into v
+func (c *Client) Get(hbUrl string, v interface{}) error {
+	for attempt := 1; ; attempt++ {
+		c.waitForRateLimit()
+		wait, retry, err := c.get(hbUrl, v)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		if attempt > c.MaxRetries {
+			return &RetryError{Url: redact(hbUrl), Attempts: attempt, Err: err}
+		}
+		if c.OnRetry != nil {
+			c.OnRetry()
+		}
+		if wait <= 0 {
+			wait = c.backoff(attempt)
+		}
+		log.WithFields(log.Fields{
+			"attempt": attempt,
+			"wait":    wait,
+			"error":   err,
+		}).Warn("Retrying Honeybadger call")
+		c.sleep(wait)
+	}
+}
+
+// Makes a single call. Returns how long the API asked us to wait, if at all,
+// and whether the call should be retried when it failed
+func (c *Client) get(hbUrl string, v interface{}) (wait time.Duration, retry bool, err error) {
+	req, err := http.NewRequest("GET", hbUrl, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if c.OnCall != nil {
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		c.OnCall(status)
+	}
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	c.rememberRateLimit(resp.Header)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, json.NewDecoder(resp.Body).Decode(v)
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = &StatusError{Url: redact(hbUrl), StatusCode: resp.StatusCode, Body: string(body)}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if wait = retryAfter(resp.Header); wait <= 0 {
+			wait = rateLimitReset(resp.Header)
+		}
+		return c.capWait(wait), true, err
+	case resp.StatusCode >= 500:
+		return c.capWait(retryAfter(resp.Header)), true, err
+	}
+	return 0, false, err
+}
+
+// Equal jitter exponential backoff: a random wait between half of and the
+// full MinBackoff * 2^(attempt-1), capped at MaxBackoff
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.MaxBackoff
+	if attempt < 32 {
+		if exp := c.MinBackoff << uint(attempt-1); exp > 0 && exp < c.MaxBackoff {
+			d = exp
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// When the rate limit headers say there are no requests left, hold off the
+// next call until the limit resets
+func (c *Client) rememberRateLimit(h http.Header) {
+	if h.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	if wait := c.capWait(rateLimitReset(h)); wait > 0 {
+		c.mu.Lock()
+		c.waitUntil = time.Now().Add(wait)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Client) waitForRateLimit() {
+	c.mu.Lock()
+	wait := time.Until(c.waitUntil)
+	c.mu.Unlock()
+	if wait > 0 {
+		log.WithFields(log.Fields{"wait": wait}).Info("Honeybadger rate limit reached, waiting")
+		c.sleep(wait)
+	}
+}
+
+// Caps a wait the API asked for at MaxBackoff, so a wrong or hostile
+// Retry-After or X-RateLimit-Reset header can't hold up the backup for hours
+func (c *Client) capWait(wait time.Duration) time.Duration {
+	if wait > c.MaxBackoff {
+		log.WithFields(log.Fields{"asked": wait, "wait": c.MaxBackoff}).Warn("Honeybadger asked to wait longer than the longest backoff, waiting the longest backoff")
+		return c.MaxBackoff
+	}
+	return wait
+}
+
+// Parses the Retry-After header, either a number of seconds or an HTTP date
+func retryAfter(h http.Header) time.Duration {
+	value := h.Get("Retry-After")
+	if len(value) < 1 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Parses the X-RateLimit-Reset header, the unix time the rate limit resets
+func rateLimitReset(h http.Header) time.Duration {
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Until(time.Unix(reset, 0))
+}
+
+// Removes the API key from a URL so it can be logged
+func redact(hbUrl string) string {
+	u, err := url.Parse(hbUrl)
+	if err != nil {
+		return hbUrl
+	}
+	values := u.Query()
+	if len(values.Get("auth_token")) > 0 {
+		values.Set("auth_token", "REDACTED")
+		u.RawQuery = values.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,137 @@
+package honeybadger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testClient(waits *[]time.Duration) *Client {
+	c := NewClient()
+	c.sleep = func(d time.Duration) { *waits = append(*waits, d) }
+	return c
+}
+
+func TestClientRetriesRateLimitAndServerErrors(t *testing.T) {
+	statuses := []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusOK}
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[calls]
+		calls++
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"total_count": 3}`))
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	var page Page[Fault]
+	var seen []int
+	retries := 0
+	c := testClient(&waits)
+	c.OnCall = func(status int) { seen = append(seen, status) }
+	c.OnRetry = func() { retries++ }
+	if err := c.Get(server.URL, &page); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || page.TotalCount != 3 {
+		t.Errorf("expected 3 calls and a total count of 3 but got %d and %d", calls, page.TotalCount)
+	}
+	if len(seen) != 3 || seen[0] != http.StatusTooManyRequests || retries != 2 {
+		t.Errorf("expected to observe every call and 2 retries but got %v and %d", seen, retries)
+	}
+	if len(waits) != 2 || waits[0] != 7*time.Second {
+		t.Errorf("expected to honor Retry-After and then back off but waited %v", waits)
+	}
+}
+
+func TestClientCapsWaitsAtMaxBackoff(t *testing.T) {
+	dayAhead := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)
+	for _, test := range []struct {
+		status  int
+		headers map[string]string
+	}{
+		{http.StatusServiceUnavailable, map[string]string{"Retry-After": "86400"}},
+		{http.StatusTooManyRequests, map[string]string{"X-RateLimit-Reset": dayAhead, "X-RateLimit-Remaining": "0"}},
+	} {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				for name, value := range test.headers {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(test.status)
+				return
+			}
+			w.Write([]byte(`{"total_count": 3}`))
+		}))
+
+		var waits []time.Duration
+		err := testClient(&waits).Get(server.URL, &Page[Fault]{})
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(waits) < 1 {
+			t.Errorf("%v: expected to wait but didn't", test.headers)
+		}
+		for _, wait := range waits {
+			if wait > MAX_BACKOFF {
+				t.Errorf("%v: expected to wait at most %v rather than a day but waited %v", test.headers, MAX_BACKOFF, waits)
+			}
+		}
+	}
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	err := testClient(&waits).Get(server.URL+"?auth_token=secret", &Page[Fault]{})
+	retryErr, ok := err.(*RetryError)
+	if !ok {
+		t.Fatalf("expected a *RetryError but got %v", err)
+	}
+	if calls != MAX_RETRIES+1 || retryErr.Attempts != calls {
+		t.Errorf("expected %d calls but got %d (%d attempts)", MAX_RETRIES+1, calls, retryErr.Attempts)
+	}
+	if statusErr, ok := retryErr.Err.(*StatusError); !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the last error to be a 503 *StatusError but got %v", retryErr.Err)
+	}
+	if retryErr.Url != server.URL+"?auth_token=REDACTED" {
+		t.Errorf("expected the API key to be redacted but got %q", retryErr.Url)
+	}
+	for i, wait := range waits {
+		if max := MIN_BACKOFF << uint(i); wait < max/2 || wait > max {
+			t.Errorf("expected retry %d to wait between %v and %v but waited %v", i+1, max/2, max, wait)
+		}
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	err := testClient(&waits).Get(server.URL, &Page[Fault]{})
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 *StatusError but got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call but got %d", calls)
+	}
+}
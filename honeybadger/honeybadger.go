@@ -1,9 +1,7 @@
 package honeybadger
 
 import (
-	"encoding/json"
-	log "github.com/Sirupsen/logrus"
-	"net/http"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
@@ -11,59 +9,45 @@ import (
 )
 
 const (
-	HB_API_ENDPOINT = "https://api.honeybadger.io/v1/projects"
-	HTTP_TIMEOUT    = time.Duration(5 * time.Minute)
+	HB_API_ENDPOINT    = "https://api.honeybadger.io/v1/projects"
+	HB_API_ENDPOINT_EU = "https://eu-api.honeybadger.io/v1/projects"
+	HTTP_TIMEOUT       = time.Duration(5 * time.Minute)
 )
 
-type Response interface {
-	SetCallNeeded(needed bool)
-	SetResultIdx(idx int)
-}
-
 type URL struct {
 	Url        *url.URL
 	PathParams []string
 	Values     url.Values
 }
 
-func CallHB(hbUrl string, results Response) {
-	client := &http.Client{Timeout: HTTP_TIMEOUT}
-	req, err := http.NewRequest("GET", hbUrl, nil)
-	if err != nil {
-		log.Fatalln(err)
+// Calls the Honeybadger API with the DefaultClient and loads the response
+// into results
+func CallHB(hbUrl string, results interface{}) error {
+	return DefaultClient.Get(hbUrl, results)
+}
+
+// Returns the projects API endpoint to call. An explicit endpoint, e.g. a proxy
+// path or a local test server, takes precedence over the region shortcut. An
+// empty region defaults to the US endpoint
+func Endpoint(region, endpoint string) (string, error) {
+	if len(endpoint) > 0 {
+		return strings.TrimRight(endpoint, "/"), nil
 	}
-	req.Header.Add("Accept", "application/json")
-	retryCount := 0
-	for retryCount < 5 {
-		resp, err := client.Do(req)
-		if err != nil {
-			if retryCount < 5 {
-				retryCount++
-				continue // try again
-			} else {
-				log.WithFields(log.Fields{"retries": retryCount}).Info("Client call failed: ")
-				log.Fatalln(err)
-			}
-		}
-		defer resp.Body.Close()
-		decoder := json.NewDecoder(resp.Body)
-		err = decoder.Decode(results)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		results.SetCallNeeded(false)
-		results.SetResultIdx(-1)
-		break
+	switch strings.ToLower(strings.TrimSpace(region)) {
+	case "", "us":
+		return HB_API_ENDPOINT, nil
+	case "eu":
+		return HB_API_ENDPOINT_EU, nil
 	}
-
+	return "", fmt.Errorf("unknown honeybadger region %q, expected us or eu", region)
 }
 
-func NewURL(u string) *URL {
+func NewURL(u string) (*URL, error) {
 	newUrl, err := url.Parse(u)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	return &URL{Url: newUrl, Values: newUrl.Query()}
+	return &URL{Url: newUrl, Values: newUrl.Query()}, err
 }
 
 // Mutates the URL to set the API Key query param
@@ -123,6 +107,15 @@ func (u *URL) ResetPathParams() {
 	u.PathParams = []string{}
 }
 
+// Parses the API's RFC 3339 times. Missing or unparsable times are zero
+func parseTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 // Insert inserts the values into the slice at the specified index, which must
 // be in range. The slice must have room for the new element.
 func Insert(slice []string, index int, values ...string) []string {
@@ -1,11 +1,18 @@
 package honeybadger
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestFaultsUrl(t *testing.T) {
-	hbUrl := NewURL(HB_API_ENDPOINT).SetApiKey("abc").SetPage(1).ProjectFaults(123)
+	u, err := NewURL(HB_API_ENDPOINT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hbUrl := u.SetApiKey("abc").SetPage(1).ProjectFaults(123)
 	expected := HB_API_ENDPOINT + "/123/faults?auth_token=abc&page=1"
 	if url := hbUrl; url != expected {
 		t.Errorf(`Error during parsing: expected %q but got %q`, expected, url)
@@ -13,9 +20,102 @@ func TestFaultsUrl(t *testing.T) {
 }
 
 func TestNoticesUrl(t *testing.T) {
-	hbUrl := NewURL(HB_API_ENDPOINT).SetApiKey("abc").SetPage(1).FaultNotices(123, 456)
+	u, err := NewURL(HB_API_ENDPOINT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hbUrl := u.SetApiKey("abc").SetPage(1).FaultNotices(123, 456)
 	expected := HB_API_ENDPOINT + "/123/faults/456/notices?auth_token=abc&page=1"
 	if url := hbUrl; url != expected {
 		t.Errorf(`Error during parsing: expected %q but got %q`, expected, url)
 	}
 }
+
+func TestEndpoint(t *testing.T) {
+	cases := []struct {
+		region, endpoint, expected string
+	}{
+		{"", "", HB_API_ENDPOINT},
+		{"us", "", HB_API_ENDPOINT},
+		{"EU", "", HB_API_ENDPOINT_EU},
+		{"eu", "http://localhost:8080/v1/projects/", "http://localhost:8080/v1/projects"},
+	}
+	for _, c := range cases {
+		if endpoint, err := Endpoint(c.region, c.endpoint); err != nil || endpoint != c.expected {
+			t.Errorf(`Endpoint(%q, %q): expected %q but got %q (%v)`, c.region, c.endpoint, c.expected, endpoint, err)
+		}
+	}
+	if _, err := Endpoint("ap", ""); err == nil {
+		t.Error("expected an error for an unknown region")
+	}
+}
+
+func TestFaultsUrlCustomEndpoint(t *testing.T) {
+	u, err := NewURL("http://localhost:8080/hb/v1/projects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hbUrl := u.SetApiKey("abc").SetPage(1).ProjectFaults(123)
+	expected := "http://localhost:8080/hb/v1/projects/123/faults?auth_token=abc&page=1"
+	if url := hbUrl; url != expected {
+		t.Errorf(`Error during parsing: expected %q but got %q`, expected, url)
+	}
+}
+
+func TestNextStopsWithErr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	faults := NewFaults(server.URL, 123, "abc", 0)
+	if _, more := faults.Next(); more {
+		t.Error("expected Next() to stop when the API call fails")
+	}
+	if statusErr, ok := faults.Err().(*StatusError); !ok || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 *StatusError but got %v", faults.Err())
+	}
+}
+
+func TestRecordsKeepRawJSON(t *testing.T) {
+	raw := `{"id": 1, "name": "app", "owner": {"id": 2, "email": "a@b.c", "name": "A"}, "new_field": [1, 2]}`
+	var page Page[Project]
+	if err := json.Unmarshal([]byte(`{"results": [`+raw+`]}`), &page); err != nil {
+		t.Fatal(err)
+	}
+	project := page.Results[0]
+	if string(project.Raw) != raw {
+		t.Errorf("expected the raw JSON %s but got %s", raw, project.Raw)
+	}
+	if project.Owner.Id != 2 || project.Owner.Email != "a@b.c" {
+		t.Errorf("expected the owner to be decoded but got %+v", project.Owner)
+	}
+
+	raw = `{"id": 3, "fault_id": 4, "breadcrumbs": {"enabled": true}, "request": {"user": {"id": 5}}}`
+	var notice Notice
+	if err := json.Unmarshal([]byte(raw), &notice); err != nil {
+		t.Fatal(err)
+	}
+	if string(notice.Raw) != raw || notice.Id != 3 || notice.FaultId != 4 {
+		t.Errorf("expected notice 3 of fault 4 with the raw JSON %s but got %+v", raw, notice)
+	}
+}
+
+func TestNoticeParquetRow(t *testing.T) {
+	var notice Notice
+	raw := `{"id": 3, "fault_id": 4, "created_at": "2024-01-01T12:00:00Z", "request": {"params": {"q": "x"}},
+		"backtrace": [{"number": "42", "file": "app/models/user.rb", "method": "save"}]}`
+	if err := json.Unmarshal([]byte(raw), &notice); err != nil {
+		t.Fatal(err)
+	}
+	row := notice.ParquetRow().(*NoticeRow)
+	if row.CreatedAt == nil || *row.CreatedAt != 1704110400000 {
+		t.Errorf("expected created_at in unix milliseconds but got %v", row.CreatedAt)
+	}
+	if row.RequestParams != `{"q":"x"}` || row.RequestSession != "" {
+		t.Errorf("expected params as JSON and no session but got %q and %q", row.RequestParams, row.RequestSession)
+	}
+	if len(row.Backtrace) != 1 || row.Backtrace[0].Number != "42" || row.Backtrace[0].File != "app/models/user.rb" {
+		t.Errorf("expected the backtrace to be kept but got %+v", row.Backtrace)
+	}
+}
@@ -2,20 +2,19 @@ package honeybadger
 
 import (
 	"encoding/json"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 )
 
+// Notices iterates through a fault's notices
 type Notices struct {
-	ProjectId     int      `json:"-"`
-	FaultId       int      `json:"-"`
-	ResultIdx     int      `json:"-"`
-	CallNeeded    bool     `json:"-"`
-	ApiKey        string   `json:"-"`
-	OccurredAfter int64    `json:"-"`
-	Results       []Notice `json:"results"`
-	TotalCount    int      `json:"total_count"`
-	CurrentPage   int      `json:"current_page"`
-	NumPages      int      `json:"num_pages"`
+	*Paginator[Notice]
+	Endpoint      string
+	ProjectId     int
+	FaultId       int
+	ApiKey        string
+	OccurredAfter int64
 }
 
 type Notice struct {
@@ -31,6 +30,7 @@ type Notice struct {
 	WebEnv           map[string]interface{} `json:"web_environment"`
 	Deploy           Deploy                 `json:"deploy"`
 	Url              string                 `json:"url"`
+	Raw              json.RawMessage        `json:"-"` // The notice exactly as the API returned it
 }
 
 type Request struct {
@@ -57,87 +57,50 @@ type Trace struct {
 	Method string      `json:"method"`
 }
 
-func NewNotices(projectId, faultId int, apiKey string, occurredAfter int64) *Notices {
-	return &Notices{
+func NewNotices(endpoint string, projectId, faultId int, apiKey string, occurredAfter int64) *Notices {
+	n := &Notices{
+		Endpoint:      endpoint,
 		ProjectId:     projectId,
 		FaultId:       faultId,
-		ResultIdx:     -1, // Increments on each call to Next()
-		CurrentPage:   -1, // So the first next page call passes
-		CallNeeded:    true,
 		ApiKey:        apiKey,
 		OccurredAfter: occurredAfter,
 	}
+	n.Paginator = NewPaginator[Notice](n.PageURL)
+	// Deep notice histories are only reachable through the links.next cursors
+	n.FollowNext = true
+	n.Paginator.ApiKey = apiKey
+	return n
 }
 
-// Loads the Notices struct with the notices on the given page argument
-func (p *Notices) GetNotices(page int) {
+// Returns the URL of the given page of the fault's notices
+func (p *Notices) PageURL(page int) (string, error) {
+	u, err := NewURL(p.Endpoint)
+	if err != nil {
+		return "", err
+	}
 	var hbUrl string
 	if p.OccurredAfter > 0 {
-		hbUrl = NewURL(HB_API_ENDPOINT).SetApiKey(p.ApiKey).SetPage(page).SetCreatedAfter(p.OccurredAfter).FaultNotices(p.ProjectId, p.FaultId)
+		hbUrl = u.SetApiKey(p.ApiKey).SetPage(page).SetCreatedAfter(p.OccurredAfter).FaultNotices(p.ProjectId, p.FaultId)
 	} else {
-		hbUrl = NewURL(HB_API_ENDPOINT).SetApiKey(p.ApiKey).SetPage(page).FaultNotices(p.ProjectId, p.FaultId)
+		hbUrl = u.SetApiKey(p.ApiKey).SetPage(page).FaultNotices(p.ProjectId, p.FaultId)
 	}
 	log.WithFields(log.Fields{
-		"url": hbUrl,
-	}).Debug("run data")
-	CallHB(hbUrl, p)
-}
-
-// Iterates through all of the notices. This makes an API call the first time
-// this function is called, and then once the end of the current page is reached
-func (p *Notices) Next() (notice *Notice, more bool) {
-	// Do we need to call the api to get more notices
-	moreResults := p.moreResults()
-
-	if moreResults {
-		p.ResultIdx = p.ResultIdx + 1
-		// Get the next notice from the list of notices returned from the API call
-		if p.ResultIdx == (len(p.Results) - 1) {
-			p.CallNeeded = true
-		}
-		return &p.Results[p.ResultIdx], true
-	}
-	return nil, false
-}
-
-func (f *Notices) hasResults() bool {
-	if f.TotalCount == 0 {
-		return false
-	}
-	return true
+		"url": redact(hbUrl),
+	}).Debug("notices page")
+	return hbUrl, err
 }
 
-func (f *Notices) moreResults() bool {
-	if f.CallNeeded {
-		if nextPage, morePages := f.NextPage(); morePages {
-			f.GetNotices(nextPage)
-			return f.hasResults()
-		} else {
-			return false
-		}
-	}
-	return true
+// When the notice was created. Notices are partitioned by this time
+func (n *Notice) Timestamp() time.Time {
+	return parseTime(n.CreatedAt)
 }
 
-// Returns the page number for the next page and true if there are more pages.
-// If no more pages are available i.e. Notices.CurrentPage == Notices.NumPages,
-// then -1 and false is returned
-func (p *Notices) NextPage() (nextPage int, morePages bool) {
-	if p.CurrentPage < p.NumPages {
-		return p.CurrentPage + 1, true
-	} else {
-		return -1, false
+// Decodes the notice and keeps a copy of the JSON it was decoded from in Raw
+func (n *Notice) UnmarshalJSON(b []byte) error {
+	type notice Notice // Doesn't have Notice's methods, so this won't recurse
+	if err := json.Unmarshal(b, (*notice)(n)); err != nil {
+		return err
 	}
-}
-
-//
-// Response methods
-//
-
-func (p *Notices) SetCallNeeded(needed bool) {
-	p.CallNeeded = needed
-}
-
-func (p *Notices) SetResultIdx(idx int) {
-	p.ResultIdx = idx
+	n.Raw = append(json.RawMessage(nil), b...)
+	return nil
 }
@@ -0,0 +1,166 @@
+package honeybadger
+
+import (
+	"net/url"
+)
+
+// A Page of results as returned by the API's list endpoints
+type Page[T any] struct {
+	Results     []T   `json:"results"`
+	TotalCount  int   `json:"total_count"`
+	CurrentPage int   `json:"current_page"`
+	NumPages    int   `json:"num_pages"`
+	Links       Links `json:"links"`
+}
+
+type Links struct {
+	Self string `json:"self"`
+	Next string `json:"next"`
+	Prev string `json:"prev"`
+}
+
+// Cursor is where a Paginator got to between pages, so iterating can carry on
+// from there after a restart
+type Cursor struct {
+	NextPage int    `json:"next_page"`
+	NextURL  string `json:"next_url,omitempty"`
+	Done     bool   `json:"done,omitempty"`
+}
+
+// Returns the URL of the page with the given page number
+type PageURL func(page int) (string, error)
+
+// Paginator iterates over the records of type T on every page of an API list
+// endpoint. Pages are requested by page number, or when FollowNext is set and
+// the API returns a links.next cursor, by following the cursor. Cursors reach
+// further back than page numbers do on long listings
+type Paginator[T any] struct {
+	Client     *Client
+	PageURL    PageURL
+	FollowNext bool   // Follow links.next cursors when the API returns them
+	ApiKey     string // Added to links.next cursors that don't include it
+	TotalCount int    // The total_count of the latest page that had one
+	// Called once every record of a page has been returned, before the next
+	// page is requested. Returning an error stops the iteration with it
+	PageDone func() error
+
+	results  []T
+	page     Cursor // Where the page of results is, to request it again
+	idx      int    // Index of the next result to return
+	nextPage int    // Page number of the next page to request
+	nextURL  string // The links.next cursor of the next page to request
+	done     bool   // There are no more pages to request
+	err      error  // Set when an API call fails, stopping Next()
+}
+
+func NewPaginator[T any](pageURL PageURL) *Paginator[T] {
+	return &Paginator[T]{
+		Client:   DefaultClient,
+		PageURL:  pageURL,
+		nextPage: 1,
+	}
+}
+
+// Iterates through all of the records. This makes an API call the first time
+// this function is called, and then once the end of the current page is
+// reached. Empty pages are skipped rather than ending the iteration
+func (p *Paginator[T]) Next() (record *T, more bool) {
+	for p.idx >= len(p.results) {
+		if p.done || p.err != nil {
+			return nil, false
+		}
+		if len(p.results) > 0 && p.PageDone != nil {
+			p.results = nil
+			if p.err = p.PageDone(); p.err != nil {
+				return nil, false
+			}
+		}
+		p.err = p.fetch()
+	}
+	record = &p.results[p.idx]
+	p.idx++
+	return record, true
+}
+
+// Returns the error that stopped Next() early, if any. Call it once Next()
+// reports there are no more records
+func (p *Paginator[T]) Err() error {
+	return p.err
+}
+
+// Where the iteration has got to. Taken in PageDone, iterating from the cursor
+// carries on with the next page
+func (p *Paginator[T]) Cursor() Cursor {
+	return Cursor{NextPage: p.nextPage, NextURL: p.nextURL, Done: p.done}
+}
+
+// Where the page of the latest record returned is. Iterating from the cursor
+// starts that page over
+func (p *Paginator[T]) PageCursor() Cursor {
+	return p.page
+}
+
+// Carry on iterating from a cursor
+func (p *Paginator[T]) Seek(c Cursor) {
+	p.nextPage, p.nextURL, p.done = c.NextPage, c.NextURL, c.Done
+	p.results, p.idx = nil, 0
+}
+
+// Requests the next page and works out where the page after it is
+func (p *Paginator[T]) fetch() error {
+	hbUrl := p.nextURL
+	if len(hbUrl) < 1 {
+		u, err := p.PageURL(p.nextPage)
+		if err != nil {
+			return err
+		}
+		hbUrl = u
+	}
+	var page Page[T]
+	if err := p.Client.Get(hbUrl, &page); err != nil {
+		return err
+	}
+	p.page = Cursor{NextPage: p.nextPage, NextURL: p.nextURL}
+	p.results, p.idx = page.Results, 0
+	if page.TotalCount > 0 {
+		p.TotalCount = page.TotalCount
+	}
+
+	switch {
+	case p.FollowNext && len(page.Links.Next) > 0:
+		next, err := p.resolveNext(hbUrl, page.Links.Next)
+		if err != nil {
+			return err
+		}
+		// A cursor pointing back at the same page would never end
+		p.nextURL, p.done = next, next == hbUrl
+	case len(p.nextURL) > 0:
+		// We were following cursors and this is the last one
+		p.done = true
+	case p.nextPage < page.NumPages:
+		p.nextPage++
+	default:
+		p.done = true
+	}
+	return nil
+}
+
+// Resolves a links.next cursor, which may be relative, against the URL of the
+// page it came from. The API key is carried over when the cursor doesn't
+// include it
+func (p *Paginator[T]) resolveNext(current, next string) (string, error) {
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	u := base.ResolveReference(ref)
+	if values := u.Query(); len(p.ApiKey) > 0 && len(values.Get("auth_token")) < 1 {
+		values.Set("auth_token", p.ApiKey)
+		u.RawQuery = values.Encode()
+	}
+	return u.String(), err
+}
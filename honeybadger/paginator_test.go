@@ -0,0 +1,172 @@
+package honeybadger
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func collectIds(t *testing.T, p *Paginator[Fault]) []int {
+	var ids []int
+	for fault, more := p.Next(); more; fault, more = p.Next() {
+		ids = append(ids, fault.Id)
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestPaginatorSkipsEmptyPages(t *testing.T) {
+	pages := map[string]string{
+		"1": `{"results": [{"id": 1}, {"id": 2}], "total_count": 3, "current_page": 1, "num_pages": 3}`,
+		"2": `{"results": [], "total_count": 3, "current_page": 2, "num_pages": 3}`,
+		"3": `{"results": [{"id": 3}], "total_count": 3, "current_page": 3, "num_pages": 3}`,
+	}
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, pages[r.URL.Query().Get("page")])
+	}))
+	defer server.Close()
+
+	p := NewPaginator[Fault](func(page int) (string, error) {
+		return server.URL + "?page=" + strconv.Itoa(page), nil
+	})
+	if ids := collectIds(t, p); fmt.Sprint(ids) != "[1 2 3]" {
+		t.Errorf("expected faults [1 2 3] but got %v", ids)
+	}
+	if calls != 3 || p.TotalCount != 3 {
+		t.Errorf("expected 3 calls and a total count of 3 but got %d and %d", calls, p.TotalCount)
+	}
+}
+
+func TestPaginatorFollowsNextLinks(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("auth_token") != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprintf(w, `{"results": [{"id": 1}], "num_pages": 1, "links": {"next": "%s/faults?cursor=a"}}`, server.URL)
+		case "a":
+			// Relative cursors without the API key are resolved against the page
+			fmt.Fprint(w, `{"results": [{"id": 2}], "links": {"next": "/faults?cursor=b"}}`)
+		case "b":
+			fmt.Fprint(w, `{"results": [{"id": 3}], "links": {}}`)
+		}
+	}))
+	defer server.Close()
+
+	p := NewPaginator[Fault](func(page int) (string, error) {
+		return server.URL + "/faults?auth_token=abc", nil
+	})
+	p.FollowNext = true
+	p.ApiKey = "abc"
+	if ids := collectIds(t, p); fmt.Sprint(ids) != "[1 2 3]" {
+		t.Errorf("expected faults [1 2 3] but got %v", ids)
+	}
+}
+
+func TestNoticesFollowCursorsPastNumPages(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("auth_token") != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls < 30 {
+			fmt.Fprintf(w, `{"results": [{"id": %d}], "num_pages": 25, "links": {"next": "?cursor=%d"}}`, calls, calls)
+		} else {
+			fmt.Fprintf(w, `{"results": [{"id": %d}], "num_pages": 25}`, calls)
+		}
+	}))
+	defer server.Close()
+
+	notices := NewNotices(server.URL, 1, 2, "abc", 0)
+	count := 0
+	for _, more := notices.Next(); more; _, more = notices.Next() {
+		count++
+	}
+	if err := notices.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 30 {
+		t.Errorf("expected 30 notices but got %d", count)
+	}
+}
+
+func TestPaginatorEmptyResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results": [], "total_count": 0, "current_page": 1, "num_pages": 0}`)
+	}))
+	defer server.Close()
+
+	p := NewPaginator[Fault](func(page int) (string, error) {
+		return server.URL, nil
+	})
+	if ids := collectIds(t, p); len(ids) != 0 {
+		t.Errorf("expected no faults but got %v", ids)
+	}
+}
+
+func TestPaginatorCarriesOnFromCursor(t *testing.T) {
+	pages := map[string]string{
+		"1": `{"results": [{"id": 1}, {"id": 2}], "current_page": 1, "num_pages": 3}`,
+		"2": `{"results": [{"id": 3}], "current_page": 2, "num_pages": 3}`,
+		"3": `{"results": [{"id": 4}], "current_page": 3, "num_pages": 3}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Query().Get("page")])
+	}))
+	defer server.Close()
+	pageURL := func(page int) (string, error) {
+		return server.URL + "?page=" + strconv.Itoa(page), nil
+	}
+
+	// Stop after the second page, as if the run was interrupted
+	p := NewPaginator[Fault](pageURL)
+	var cursors []Cursor
+	p.PageDone = func() error {
+		cursors = append(cursors, p.Cursor())
+		return nil
+	}
+	var ids []int
+	for fault, more := p.Next(); more && len(cursors) < 2; fault, more = p.Next() {
+		ids = append(ids, fault.Id)
+	}
+	if fmt.Sprint(ids) != "[1 2 3]" || len(cursors) != 2 {
+		t.Fatalf("expected faults [1 2 3] and 2 cursors but got %v and %v", ids, cursors)
+	}
+
+	// Fault 4 was the last returned, starting its page over requests page 3
+	if page := p.PageCursor(); page.NextPage != 3 {
+		t.Errorf("expected the cursor of page 3 but got %v", page)
+	}
+
+	resumed := NewPaginator[Fault](pageURL)
+	resumed.Seek(cursors[1])
+	if ids := collectIds(t, resumed); fmt.Sprint(ids) != "[4]" {
+		t.Errorf("expected to carry on with fault [4] but got %v", ids)
+	}
+
+	// An error from PageDone stops the iteration before the next page
+	stopped := NewPaginator[Fault](pageURL)
+	stop := errors.New("stopped")
+	stopped.PageDone = func() error {
+		return stop
+	}
+	ids = nil
+	for fault, more := stopped.Next(); more; fault, more = stopped.Next() {
+		ids = append(ids, fault.Id)
+	}
+	if fmt.Sprint(ids) != "[1 2]" || stopped.Err() != stop {
+		t.Errorf("expected faults [1 2] then the error but got %v and %v", ids, stopped.Err())
+	}
+}
@@ -0,0 +1,176 @@
+package honeybadger
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The Parquet rows the records are written as. The schema is stable: nested
+// objects with open ended keys, like request params, are kept as JSON strings
+// while backtraces are a nested list column. Times are unix milliseconds
+
+type FaultRow struct {
+	Id            int64    `parquet:"name=id, type=INT64"`
+	ProjectId     int64    `parquet:"name=project_id, type=INT64"`
+	Klass         string   `parquet:"name=klass, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Component     string   `parquet:"name=component, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Action        string   `parquet:"name=action, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Environment   string   `parquet:"name=environment, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Resolved      bool     `parquet:"name=resolved, type=BOOLEAN"`
+	Ignored       bool     `parquet:"name=ignored, type=BOOLEAN"`
+	CreatedAt     *int64   `parquet:"name=created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	CommentsCount int32    `parquet:"name=comments_count, type=INT32"`
+	Message       string   `parquet:"name=message, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LastNoticeAt  *int64   `parquet:"name=last_notice_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Tags          []string `parquet:"name=tags, type=LIST, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Assignee      string   `parquet:"name=assignee, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Tickets       []string `parquet:"name=tickets, type=LIST, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+type NoticeRow struct {
+	Id                  int64      `parquet:"name=id, type=INT64"`
+	FaultId             int64      `parquet:"name=fault_id, type=INT64"`
+	Environment         string     `parquet:"name=environment, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	CreatedAt           *int64     `parquet:"name=created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Message             string     `parquet:"name=message, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Token               string     `parquet:"name=token, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Url                 string     `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RequestUrl          string     `parquet:"name=request_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RequestComponent    string     `parquet:"name=request_component, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	RequestAction       string     `parquet:"name=request_action, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	RequestParams       string     `parquet:"name=request_params, type=BYTE_ARRAY, convertedtype=JSON"`
+	RequestSession      string     `parquet:"name=request_session, type=BYTE_ARRAY, convertedtype=JSON"`
+	RequestContext      string     `parquet:"name=request_context, type=BYTE_ARRAY, convertedtype=JSON"`
+	WebEnvironment      string     `parquet:"name=web_environment, type=BYTE_ARRAY, convertedtype=JSON"`
+	DeployEnvironment   string     `parquet:"name=deploy_environment, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeployRevision      string     `parquet:"name=deploy_revision, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeployRepository    string     `parquet:"name=deploy_repository, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeployLocalUsername string     `parquet:"name=deploy_local_username, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeployCreatedAt     *int64     `parquet:"name=deploy_created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	Backtrace           []TraceRow `parquet:"name=backtrace, type=LIST, convertedtype=LIST"`
+	ApplicationTrace    []TraceRow `parquet:"name=application_trace, type=LIST, convertedtype=LIST"`
+}
+
+type TraceRow struct {
+	Number string `parquet:"name=number, type=BYTE_ARRAY, convertedtype=UTF8"`
+	File   string `parquet:"name=file, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Method string `parquet:"name=method, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+type ProjectRow struct {
+	Id                   int64    `parquet:"name=id, type=INT64"`
+	Name                 string   `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CreatedAt            *int64   `parquet:"name=created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	DisablePublicLinks   bool     `parquet:"name=disable_public_links, type=BOOLEAN"`
+	TeamId               int64    `parquet:"name=team_id, type=INT64"`
+	OwnerId              int64    `parquet:"name=owner_id, type=INT64"`
+	OwnerEmail           string   `parquet:"name=owner_email, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OwnerName            string   `parquet:"name=owner_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Environments         []string `parquet:"name=environments, type=LIST, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	LastNoticeAt         *int64   `parquet:"name=last_notice_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	EarliestNoticeAt     *int64   `parquet:"name=earliest_notice_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	UnresolvedFaultCount int64    `parquet:"name=unresolved_fault_count, type=INT64"`
+	FaultCount           int64    `parquet:"name=fault_count, type=INT64"`
+	Active               bool     `parquet:"name=active, type=BOOLEAN"`
+}
+
+func (f *Fault) ParquetRow() interface{} {
+	return &FaultRow{
+		Id:            int64(f.Id),
+		ProjectId:     int64(f.ProjectId),
+		Klass:         f.Klass,
+		Component:     f.Component,
+		Action:        f.Action,
+		Environment:   f.Environment,
+		Resolved:      f.Resolved,
+		Ignored:       f.Ignored,
+		CreatedAt:     parseMillis(f.CreatedAt),
+		CommentsCount: int32(f.CommentsCount),
+		Message:       f.Message,
+		LastNoticeAt:  parseMillis(f.LastNoticeAt),
+		Tags:          f.Tags,
+		Assignee:      f.Assignee,
+		Tickets:       f.Tickets,
+	}
+}
+
+func (n *Notice) ParquetRow() interface{} {
+	return &NoticeRow{
+		Id:                  int64(n.Id),
+		FaultId:             int64(n.FaultId),
+		Environment:         n.Environment.Name,
+		CreatedAt:           parseMillis(n.CreatedAt),
+		Message:             n.Message,
+		Token:               n.Token,
+		Url:                 n.Url,
+		RequestUrl:          n.Request.Url,
+		RequestComponent:    n.Request.Component,
+		RequestAction:       n.Request.Action,
+		RequestParams:       jsonString(n.Request.Params),
+		RequestSession:      jsonString(n.Request.Session),
+		RequestContext:      jsonString(n.Request.Context),
+		WebEnvironment:      jsonString(n.WebEnv),
+		DeployEnvironment:   n.Deploy.Environment,
+		DeployRevision:      n.Deploy.Revision,
+		DeployRepository:    n.Deploy.Repository,
+		DeployLocalUsername: n.Deploy.LocalUsername,
+		DeployCreatedAt:     parseMillis(n.Deploy.CreatedAt),
+		Backtrace:           traceRows(n.Backtrace),
+		ApplicationTrace:    traceRows(n.ApplicationTrace),
+	}
+}
+
+func (p *Project) ParquetRow() interface{} {
+	environments := make([]string, 0, len(p.Environments))
+	for _, e := range p.Environments {
+		environments = append(environments, e.Name)
+	}
+	return &ProjectRow{
+		Id:                   int64(p.Id),
+		Name:                 p.Name,
+		CreatedAt:            timeMillis(p.CreatedAt),
+		DisablePublicLinks:   p.DisablePublicLinks,
+		TeamId:               int64(p.TeamId),
+		OwnerId:              int64(p.Owner.Id),
+		OwnerEmail:           p.Owner.Email,
+		OwnerName:            p.Owner.Name,
+		Environments:         environments,
+		LastNoticeAt:         timeMillis(p.LastNoticeAt),
+		EarliestNoticeAt:     timeMillis(p.EarliestNoticeAt),
+		UnresolvedFaultCount: int64(p.UnresolvedFaultCount),
+		FaultCount:           int64(p.FaultCount),
+		Active:               p.Active,
+	}
+}
+
+func traceRows(traces []Trace) []TraceRow {
+	rows := make([]TraceRow, 0, len(traces))
+	for _, t := range traces {
+		rows = append(rows, TraceRow{Number: t.Number.String(), File: t.File, Method: t.Method})
+	}
+	return rows
+}
+
+// Returns nil for missing or unparsable times, so they're null in Parquet
+func parseMillis(value string) *int64 {
+	return timeMillis(parseTime(value))
+}
+
+func timeMillis(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return &ms
+}
+
+func jsonString(m map[string]interface{}) string {
+	if m == nil {
+		return ""
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+// Prints where each project's next backup starts from, and the runs that
+// wrote a manifest. Given a manifest, prints the objects of that run instead
+func inspect(ctx *Context, manifestKey string, out io.Writer) error {
+	store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Store = store
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	if len(manifestKey) > 0 {
+		manifest, err := storage.ReadManifest(ctx.Store, manifestKey)
+		if err != nil {
+			return fmt.Errorf("reading manifest %s: %v", manifestKey, err)
+		}
+		fmt.Fprintf(w, "Run %s %s\n\n", manifest.RunId, runStatus(manifest))
+		fmt.Fprintln(w, "KEY\tPROJECT\tTYPE\tRECORDS\tBYTES\tFIRST\tLAST")
+		for _, entry := range manifest.Objects {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", entry.Key, entry.Project, entry.Type, entry.Records, entry.Bytes, formatTime(entry.MinTime), formatTime(entry.MaxTime))
+		}
+		return nil
+	}
+
+	state, err := storage.ReadState(ctx.Store, storage.JoinKey(ctx.S3prefix, STATE_FILE))
+	if err == storage.ErrNotExist {
+		fmt.Fprintln(w, "No run state, nothing has been backed up yet")
+	} else if err != nil {
+		return err
+	} else {
+		fmt.Fprintf(w, "Last run %s %s", state.LastRunId, state.LastRunStatus)
+		if len(state.LastRunError) > 0 {
+			fmt.Fprintf(w, ": %s", state.LastRunError)
+		}
+		fmt.Fprint(w, "\n\n")
+		fmt.Fprintln(w, "PROJECT ID\tPROJECT\tFAULTS WATERMARK\tNOTICES WATERMARK")
+		ids := make([]int, 0, len(state.Projects))
+		for id := range state.Projects {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			p := state.Projects[id]
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", id, p.Name, formatUnix(p.Faults), formatUnix(p.Notices))
+		}
+		// Projects migrated from the legacy run data aren't known by ID yet
+		names := make([]string, 0, len(state.Legacy))
+		for name := range state.Legacy {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "\t%s\t%s\t%s\n", name, formatUnix(state.Legacy[name]), formatUnix(state.Legacy[name]))
+		}
+	}
+
+	objects, err := ctx.Store.List(storage.ManifestPrefix(ctx.S3prefix))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nRUN\tSTATUS\tDURATION\tOBJECTS\tRECORDS\tBYTES\tDUPLICATES")
+	for _, object := range objects {
+		manifest, err := storage.ReadManifest(ctx.Store, object.Key)
+		if err != nil {
+			return fmt.Errorf("reading manifest %s: %v", object.Key, err)
+		}
+		var records, bytes int64
+		for _, entry := range manifest.Objects {
+			records += entry.Records
+			bytes += entry.Bytes
+		}
+		duration := manifest.RunEnd.Sub(manifest.RunStart).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", manifest.RunId, runStatus(manifest), duration, len(manifest.Objects), records, bytes, manifest.Duplicates)
+	}
+	return nil
+}
+
+func runStatus(m *storage.Manifest) string {
+	if len(m.Error) > 0 {
+		return storage.RUN_FAILED + ": " + m.Error
+	}
+	return storage.RUN_SUCCEEDED
+}
+
+func formatUnix(t int64) string {
+	if t == 0 {
+		return "never"
+	}
+	return formatTime(time.Unix(t, 0))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
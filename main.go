@@ -1,9 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
+	"github.com/MasteryConnect/honeybadger-s3/s3"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"os"
+	"strings"
+	"time"
 )
 
 func init() {
@@ -16,12 +23,154 @@ func init() {
 	log.SetLevel(log.InfoLevel)
 }
 
+// Flags of where the archives are, shared by every command
+var storeFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "s3-bucket, b",
+		Usage:  "AWS S3 bucket to backup to",
+		EnvVar: "S3_BUCKET",
+	}, cli.StringFlag{
+		Name:   "s3-directory, d",
+		Usage:  "(optional) the directory in the AWS S3 bucket to back up to",
+		EnvVar: "S3_DIRECTORY",
+	}, cli.StringFlag{
+		Name:   "s3-region",
+		Usage:  "(optional) the AWS region of the S3 bucket. Defaults to us-east-1",
+		EnvVar: "S3_REGION",
+	}, cli.StringFlag{
+		Name:   "s3-endpoint",
+		Usage:  "(optional) the URL of an S3 compatible store such as MinIO or Ceph RGW e.g. http://localhost:9000",
+		EnvVar: "S3_ENDPOINT",
+	}, cli.BoolFlag{
+		Name:   "s3-force-path-style",
+		Usage:  "(optional) address buckets by path (<endpoint>/<bucket>) instead of by host (<bucket>.<endpoint>), as most S3 compatible stores require",
+		EnvVar: "S3_FORCE_PATH_STYLE",
+	}, cli.BoolFlag{
+		Name:   "s3-insecure-skip-verify",
+		Usage:  "(optional) don't verify the S3 endpoint's TLS certificate e.g. for a self-signed certificate",
+		EnvVar: "S3_INSECURE_SKIP_VERIFY",
+	}, cli.StringFlag{
+		Name:   "destination, o",
+		Usage:  "(optional) where to backup to instead of --s3-bucket, either s3://<bucket> or file://<directory> e.g. file:///var/backups/honeybadger",
+		EnvVar: "DESTINATION",
+	},
+}
+
+// Flags of the backup command. They're global flags too, as backing up is what
+// runs when no command is given
+var backupFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "config, C",
+		Usage:  "(optional) a YAML file of backup jobs to run, each with its own Honeybadger.io key, projects, destination and output. The other flags are the defaults of every job. See the README",
+		EnvVar: "CONFIG",
+	}, cli.StringFlag{
+		Name:   "jobs, j",
+		Usage:  "(optional) comma separated list of the jobs in the config file to run. If not set, all jobs are run",
+		EnvVar: "JOBS",
+	}, cli.StringFlag{
+		Name:   "projects, p",
+		Usage:  "(optional) comma separated list of projects to backup. If not set, all projects are backed up",
+		EnvVar: "PROJECTS",
+	}, cli.StringFlag{
+		Name:   "honeybadger-key, k",
+		Usage:  "your Honeybadger.io API key",
+		EnvVar: "HB_API_KEY",
+	}, cli.StringFlag{
+		Name:   "honeybadger-region",
+		Usage:  "(optional) the Honeybadger.io region to call, either us or eu. Defaults to us",
+		EnvVar: "HB_REGION",
+	}, cli.StringFlag{
+		Name:   "honeybadger-endpoint",
+		Usage:  "(optional) the Honeybadger.io projects API URL e.g. a proxy or local test server. Overrides --honeybadger-region",
+		EnvVar: "HB_API_ENDPOINT",
+	}, cli.BoolFlag{
+		Name:   "raw",
+		Usage:  "(optional) archive the exact JSON returned by the Honeybadger.io API, including fields this tool doesn't know about",
+		EnvVar: "RAW",
+	}, cli.StringFlag{
+		Name:   "format, f",
+		Value:  string(storage.FormatNDJSON),
+		Usage:  "(optional) how records are written to each file, one of ndjson (one JSON record per line), json (a JSON array) or parquet",
+		EnvVar: "FORMAT",
+	}, cli.StringFlag{
+		Name:   "compression, c",
+		Value:  string(storage.CompressionNone),
+		Usage:  "(optional) how files are compressed, one of none, gzip or zstd. Parquet files compress their columns with it instead",
+		EnvVar: "COMPRESSION",
+	}, cli.IntFlag{
+		Name:   "parquet-row-group-size",
+		Value:  storage.DEFAULT_ROW_GROUP_SIZE,
+		Usage:  "(optional) the number of rows in each row group of parquet files",
+		EnvVar: "PARQUET_ROW_GROUP_SIZE",
+	}, cli.StringFlag{
+		Name:   "layout",
+		Value:  "flat",
+		Usage:  "(optional) how files are laid out, flat (prefix/<project>-notices-<time>.json) or hive (prefix/type=notices/project=<project>/dt=<date>/hour=<hour>/<time>.json) partitioned by record time for Athena and Glue",
+		EnvVar: "LAYOUT",
+	}, cli.StringFlag{
+		Name:   "key-template",
+		Usage:  "(optional) names files with a template instead of a layout e.g. \"[{prefix}/][{env}/][{project_id}/]{type}/{date}/{run_time}{ext}\". See the README for the variables",
+		EnvVar: "KEY_TEMPLATE",
+	}, cli.StringFlag{
+		Name:   "environment, e",
+		Usage:  "(optional) the environment being backed up, for the {env} variable of key templates",
+		EnvVar: "ENVIRONMENT",
+	}, cli.DurationFlag{
+		Name:   "lock-lease",
+		Value:  storage.DEFAULT_LOCK_LEASE,
+		Usage:  "(optional) how long a run holds the lock on the S3 directory without renewing it. A crashed run's lock can be taken over once it expires",
+		EnvVar: "LOCK_LEASE",
+	}, cli.DurationFlag{
+		Name:   "watermark-overlap",
+		Value:  storage.DEFAULT_WATERMARK_OVERLAP,
+		Usage:  "(optional) how far before the latest fault and notice backed up the next run looks again, for notices that arrive late. Records backed up by the previous run aren't backed up again",
+		EnvVar: "WATERMARK_OVERLAP",
+	}, cli.DurationFlag{
+		Name:   "checkpoint-interval",
+		Value:  DEFAULT_CHECKPOINT_INTERVAL,
+		Usage:  "(optional) how often to checkpoint the progress of a project, so an interrupted run carries on from there next run. 0 to never. Parquet files can't be checkpointed",
+		EnvVar: "CHECKPOINT_INTERVAL",
+	}, cli.StringFlag{
+		Name:   "metrics-textfile",
+		Usage:  "(optional) write Prometheus metrics of the runs to this file for node_exporter's textfile collector e.g. /var/lib/node_exporter/honeybadger-s3.prom",
+		EnvVar: "METRICS_TEXTFILE",
+	}, cli.StringFlag{
+		Name:   "last-run, l",
+		Usage:  "the last time this process ran, the time from which this will search for new faults. Use the following format: <year><month><day><hour><minute><second> e.g. 20150430140508",
+		EnvVar: "LAST_RUN",
+	},
+}
+
+// Flags of the daemon command, as well as the backup flags
+var daemonFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "schedule, s",
+		Usage:  "when to back up, a cron expression e.g. \"*/15 * * * *\", @hourly, @every 15m or just an interval e.g. 15m. Times are in the local time zone",
+		EnvVar: "SCHEDULE",
+	}, cli.DurationFlag{
+		Name:   "jitter",
+		Usage:  "(optional) the most to delay each run by, at random, to spread out runs due at the same time",
+		EnvVar: "JITTER",
+	}, cli.BoolFlag{
+		Name:   "run-on-start",
+		Usage:  "(optional) back up as soon as the daemon starts, as well as on the schedule",
+		EnvVar: "RUN_ON_START",
+	}, cli.StringFlag{
+		Name:   "metrics-listen",
+		Usage:  "(optional) the address to serve Prometheus metrics on at /metrics e.g. :9464",
+		EnvVar: "METRICS_LISTEN",
+	},
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "honeybadger-s3"
 	app.Version = "1.0"
 	app.Usage = `
-   backup honeybadger.io faults to AWS S3.
+   backup honeybadger.io faults to AWS S3, and manage the archives.
+
+   Without a command, backs up. The backup command's flags can be given as
+   global flags in that case.
 
    For S3 access credentials, one of the following is required:
    1. set up the following environment variables:
@@ -30,46 +179,352 @@ func main() {
    2. set up ~/.aws/credentials (shared credentials)
    3. run from an ec2 machine and user that has permission to S3 (ec2 role)
 	`
-	app.Flags = []cli.Flag{
-		cli.StringFlag{
-			Name:   "s3-bucket, b",
-			Usage:  "AWS S3 bucket to backup to",
-			EnvVar: "S3_BUCKET",
-		}, cli.StringFlag{
-			Name:   "s3-directory, d",
-			Usage:  "(optional) the directory in the AWS S3 bucket to back up to",
-			EnvVar: "S3_DIRECTORY",
-		}, cli.StringFlag{
-			Name:   "projects, p",
-			Usage:  "(optional) comma separated list of projects to backup. If not set, all projects are backed up",
-			EnvVar: "PROJECTS",
-		}, cli.StringFlag{
-			Name:   "honeybadger-key, k",
-			Usage:  "your Honeybadger.io API key",
-			EnvVar: "HB_API_KEY",
-		}, cli.StringFlag{
-			Name:   "last-run, l",
-			Usage:  "the last time this process ran, the time from which this will search for new faults. Use the following format: <year><month><day><hour><minute><second> e.g. 20150430140508",
-			EnvVar: "LAST_RUN",
+	app.Flags = append(append([]cli.Flag{}, storeFlags...), backupFlags...)
+	app.Commands = []cli.Command{
+		{
+			Name:   "backup",
+			Usage:  "back up faults and notices since the last run. This is the default command",
+			Flags:  backupFlags,
+			Action: runBackup,
+		},
+		{
+			Name:   "daemon",
+			Usage:  "keep running, backing up on a schedule instead of once",
+			Flags:  append(append([]cli.Flag{}, backupFlags...), daemonFlags...),
+			Action: runDaemon,
+		},
+		{
+			Name:  "verify",
+			Usage: "check archived files are intact, exiting non-zero if any are missing, truncated or corrupt",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "manifest, m",
+					Usage: "(optional) the key of the run manifest to check the files of e.g. honeybadger/manifests/20240101120000.json. If not set, every file under --s3-directory is read back instead",
+				},
+			},
+			Action: func(c *cli.Context) {
+				configureStore(c.GlobalString, c.GlobalBool)
+				err := verify(storeContext(c), c.String("manifest"))
+				if err != nil {
+					log.Fatal(err)
+				}
+			},
 		},
+		{
+			Name:  "restore",
+			Usage: "copy archived files to another destination e.g. a local directory, checking them against their manifest",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "to, t",
+					Usage: "where to copy the files to, either s3://<bucket> or file://<directory> e.g. file:///tmp/restore. Files keep their keys",
+				},
+				cli.StringFlag{
+					Name:  "manifest, m",
+					Usage: "(optional) the key of the run manifest to copy the files of. If not set, every file under --s3-directory is copied",
+				},
+				cli.StringFlag{
+					Name:  "project",
+					Usage: "(optional) only copy the files of this project. Needs --manifest",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Usage: "(optional) only copy files of this record type, projects, faults or notices. Needs --manifest",
+				},
+			},
+			Action: func(c *cli.Context) {
+				configureStore(c.GlobalString, c.GlobalBool)
+				if len(c.String("to")) < 1 {
+					log.Fatal("to argument is required!")
+				}
+				err := restore(storeContext(c), c.String("manifest"), c.String("to"), c.String("project"), c.String("type"))
+				if err != nil {
+					log.Fatal(err)
+				}
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "delete the files of runs older than --older-than, and their manifests",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "delete the files of runs that started longer ago than this e.g. 2160h for 90 days",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "(optional) log what would be deleted without deleting it",
+				},
+			},
+			Action: func(c *cli.Context) {
+				configureStore(c.GlobalString, c.GlobalBool)
+				if c.Duration("older-than") <= 0 {
+					log.Fatal("older-than argument is required!")
+				}
+				err := prune(storeContext(c), time.Now().Add(-c.Duration("older-than")), c.Bool("dry-run"))
+				if err != nil {
+					log.Fatal(err)
+				}
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "print where each project's next backup starts from and the runs with a manifest, or the files of one run",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "manifest, m",
+					Usage: "(optional) the key of the run manifest to print the files of",
+				},
+			},
+			Action: func(c *cli.Context) {
+				configureStore(c.GlobalString, c.GlobalBool)
+				err := inspect(storeContext(c), c.String("manifest"), os.Stdout)
+				if err != nil {
+					log.Fatal(err)
+				}
+			},
+		},
+	}
+	app.Action = runBackup
+
+	app.Run(os.Args)
+}
+
+// Backs up, either as the backup command or as the default command with its
+// flags given as global flags. With a config file, backs up its jobs
+func runBackup(c *cli.Context) {
+	f := flagReader{c}
+	jobs, err := jobsFromFlags(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = backupJobs(jobs)
+	writeMetricsTextfile(f.String("metrics-textfile"))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Runs the jobs on their schedules until the process is stopped
+func runDaemon(c *cli.Context) {
+	jobs, err := jobsFromFlags(flagReader{c})
+	if err != nil {
+		log.Fatal(err)
+	}
+	options := daemonOptions{
+		RunOnStart:      c.Bool("run-on-start"),
+		MetricsListen:   c.String("metrics-listen"),
+		MetricsTextfile: c.String("metrics-textfile"),
+	}
+	if err := daemon(jobs, options); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// The jobs in the config file, or the job the flags describe without one
+func jobsFromFlags(f flagReader) ([]Job, error) {
+	job := jobFromFlags(f)
+	path := f.String("config")
+	if len(path) < 1 {
+		if len(f.String("jobs")) > 0 {
+			return nil, errors.New("jobs needs a config argument to read them from")
+		}
+		return []Job{job}, nil
+	}
+	jobs, err := loadJobs(path, job)
+	if err != nil {
+		return nil, err
+	}
+	return selectJobs(jobs, f.String("jobs"))
+}
+
+// The job the flags describe, and the defaults of the jobs in a config file
+func jobFromFlags(f flagReader) Job {
+	job := Job{
+		S3Bucket:             f.String("s3-bucket"),
+		S3Directory:          f.String("s3-directory"),
+		S3Region:             f.String("s3-region"),
+		S3Endpoint:           f.String("s3-endpoint"),
+		S3ForcePathStyle:     f.Bool("s3-force-path-style"),
+		S3InsecureSkipVerify: f.Bool("s3-insecure-skip-verify"),
+		Destination:          f.String("destination"),
+		HoneybadgerKey:       f.String("honeybadger-key"),
+		HoneybadgerRegion:    f.String("honeybadger-region"),
+		HoneybadgerEndpoint:  f.String("honeybadger-endpoint"),
+		Raw:                  f.Bool("raw"),
+		Format:               f.String("format"),
+		Compression:          f.String("compression"),
+		ParquetRowGroupSize:  f.Int("parquet-row-group-size"),
+		Layout:               f.String("layout"),
+		KeyTemplate:          f.String("key-template"),
+		Environment:          f.String("environment"),
+		LockLease:            f.Duration("lock-lease"),
+		WatermarkOverlap:     f.Duration("watermark-overlap"),
+		CheckpointInterval:   f.Duration("checkpoint-interval"),
+		LastRun:              f.String("last-run"),
+		Schedule:             f.String("schedule"),
+		Jitter:               f.Duration("jitter"),
+	}
+	if projects := f.String("projects"); len(projects) > 0 {
+		job.Projects = strings.Split(projects, ",")
+	}
+	return job
+}
+
+// Backs up each job in turn, carrying on past jobs that fail
+func backupJobs(jobs []Job) error {
+	if len(jobs) == 1 {
+		return backupJob(jobs[0], nil)
 	}
-	app.Action = func(c *cli.Context) {
-		if len(c.String("s3-bucket")) <= 0 {
-			log.Fatal("s3-bucket argument is required!")
+	var failed []string
+	for _, job := range jobs {
+		log.WithFields(log.Fields{"job": job.Name}).Info("Running job")
+		if err := backupJob(job, nil); err != nil {
+			log.WithFields(log.Fields{"job": job.Name}).Error(err)
+			failed = append(failed, job.Name)
 		}
-		if len(c.String("honeybadger-key")) <= 0 {
-			log.Fatal("honeybadger-key argument is required!")
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d jobs failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Backs up a job, recording how the run went in the metrics. setup, if set,
+// can change the run's context before it starts
+func backupJob(job Job, setup func(*Context)) error {
+	started := time.Now()
+	ctx, err := jobContext(job)
+	if err == nil {
+		if setup != nil {
+			setup(ctx)
 		}
-		backup(
-			&Context{
-				S3bucket:           c.String("s3-bucket"),
-				S3prefix:           c.String("s3-directory"),
-				ProjectIncludeList: c.String("projects"),
-				HoneybadgerKey:     c.String("honeybadger-key"),
-				LastRun:            c.String("last-run"),
-			},
-		)
+		err = backup(ctx)
 	}
+	recordRun(jobName(job), ctx, started, err)
+	return err
+}
 
-	app.Run(os.Args)
+// The context of a job's run, once its settings are checked. The S3 client is
+// configured for the job
+func jobContext(job Job) (*Context, error) {
+	if len(job.S3Bucket) <= 0 && len(job.Destination) <= 0 {
+		return nil, errors.New("s3-bucket or destination argument is required!")
+	}
+	if len(job.HoneybadgerKey) <= 0 {
+		return nil, errors.New("honeybadger-key argument is required!")
+	}
+	endpoint, err := hb.Endpoint(job.HoneybadgerRegion, job.HoneybadgerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	format, err := storage.ParseFormat(job.Format)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := storage.ParseCompression(job.Compression)
+	if err != nil {
+		return nil, err
+	}
+	template, ok := layouts[job.Layout]
+	if !ok {
+		return nil, fmt.Errorf("unknown layout %q, expected flat or hive", job.Layout)
+	}
+	if len(job.KeyTemplate) > 0 {
+		template = job.KeyTemplate
+	}
+	keyTemplate, err := storage.ParseKeyTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	if format == storage.FormatParquet && job.Raw {
+		return nil, errors.New("raw can't be used with the parquet format, parquet files have a fixed schema")
+	}
+	// Jobs with the same S3 options share a client
+	s3.Configure(s3.Options{
+		Region:             job.S3Region,
+		Endpoint:           job.S3Endpoint,
+		ForcePathStyle:     job.S3ForcePathStyle,
+		InsecureSkipVerify: job.S3InsecureSkipVerify,
+	})
+	return &Context{
+		S3bucket:            job.S3Bucket,
+		S3prefix:            job.S3Directory,
+		Destination:         job.Destination,
+		ProjectIncludeList:  strings.Join(job.Projects, ","),
+		HoneybadgerKey:      job.HoneybadgerKey,
+		HoneybadgerEndpoint: endpoint,
+		LastRun:             job.LastRun,
+		Raw:                 job.Raw,
+		KeyTemplate:         keyTemplate,
+		Environment:         job.Environment,
+		LockLease:           job.LockLease,
+		CheckpointInterval:  job.CheckpointInterval,
+		WatermarkOverlap:    job.WatermarkOverlap,
+		Output: storage.Output{
+			Format:       format,
+			Compression:  compression,
+			RowGroupSize: job.ParquetRowGroupSize,
+		},
+	}, nil
+}
+
+// flagReader reads a flag of a command, or the global flag of the same name
+// when it was only given before the command
+type flagReader struct {
+	c *cli.Context
+}
+
+func (f flagReader) global(name string) bool {
+	return !f.c.IsSet(name) && f.c.GlobalIsSet(name)
+}
+
+func (f flagReader) String(name string) string {
+	if f.global(name) {
+		return f.c.GlobalString(name)
+	}
+	return f.c.String(name)
+}
+
+func (f flagReader) Bool(name string) bool {
+	if f.global(name) {
+		return f.c.GlobalBool(name)
+	}
+	return f.c.Bool(name)
+}
+
+func (f flagReader) Int(name string) int {
+	if f.global(name) {
+		return f.c.GlobalInt(name)
+	}
+	return f.c.Int(name)
+}
+
+func (f flagReader) Duration(name string) time.Duration {
+	if f.global(name) {
+		return f.c.GlobalDuration(name)
+	}
+	return f.c.Duration(name)
+}
+
+// The context of a command that works on the archives rather than backing up
+func storeContext(c *cli.Context) *Context {
+	return &Context{
+		S3bucket:    c.GlobalString("s3-bucket"),
+		S3prefix:    c.GlobalString("s3-directory"),
+		Destination: c.GlobalString("destination"),
+	}
+}
+
+// Checks there's somewhere to back up to and configures the S3 connection from
+// the global flags
+func configureStore(stringFlag func(string) string, boolFlag func(string) bool) {
+	if len(stringFlag("s3-bucket")) <= 0 && len(stringFlag("destination")) <= 0 {
+		log.Fatal("s3-bucket or destination argument is required!")
+	}
+	s3.Configure(s3.Options{
+		Region:             stringFlag("s3-region"),
+		Endpoint:           stringFlag("s3-endpoint"),
+		ForcePathStyle:     boolFlag("s3-force-path-style"),
+		InsecureSkipVerify: boolFlag("s3-insecure-skip-verify"),
+	})
 }
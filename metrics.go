@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
+	"github.com/MasteryConnect/honeybadger-s3/metrics"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// The metrics of the runs in this process, served by the daemon on
+// --metrics-listen and written to --metrics-textfile
+var registry = metrics.NewRegistry()
+
+var (
+	runsTotal       = registry.Counter("honeybadger_s3_runs_total", "Backup runs by how they ended: succeeded, failed or skipped as another run held the lock", "backup_job", "status")
+	lastRunSuccess  = registry.Gauge("honeybadger_s3_last_run_success", "Whether the last run of the backup job succeeded", "backup_job")
+	lastRunDuration = registry.Gauge("honeybadger_s3_last_run_duration_seconds", "How long the last run of the backup job took", "backup_job")
+	lastRunTime     = registry.Gauge("honeybadger_s3_last_run_timestamp_seconds", "When the last run of the backup job finished, as a unix time", "backup_job")
+	lastSuccessTime = registry.Gauge("honeybadger_s3_last_success_timestamp_seconds", "When the last successful run of the backup job finished, as a unix time", "backup_job")
+	recordsArchived = registry.Counter("honeybadger_s3_records_archived_total", "Records archived by project and record type", "backup_job", "project", "type")
+	bytesUploaded   = registry.Counter("honeybadger_s3_bytes_uploaded_total", "Bytes of archived files uploaded by project and record type", "backup_job", "project", "type")
+	duplicates      = registry.Counter("honeybadger_s3_duplicate_notices_total", "Notices not archived again as an earlier run had", "backup_job")
+	lastBackupTime  = registry.Gauge("honeybadger_s3_last_backup_timestamp_seconds", "The latest fault or notice of each project that's committed as backed up, its watermark, as a unix time", "backup_job", "project", "type")
+	apiCalls        = registry.Counter("honeybadger_s3_api_calls_total", "Calls to the Honeybadger.io API by response status, 0 when there was no response", "status")
+	apiRetries      = registry.Counter("honeybadger_s3_api_retries_total", "Calls to the Honeybadger.io API that were retried")
+	apiRateLimited  = registry.Counter("honeybadger_s3_api_rate_limited_total", "Calls to the Honeybadger.io API rate limited with a 429")
+)
+
+func init() {
+	hb.DefaultClient.OnCall = func(status int) {
+		apiCalls.Inc(strconv.Itoa(status))
+		if status == 429 {
+			apiRateLimited.Inc()
+		}
+	}
+	hb.DefaultClient.OnRetry = func() {
+		apiRetries.Inc()
+	}
+}
+
+// Records how a run of a job went. The context is nil when the job's settings
+// were wrong
+func recordRun(job string, ctx *Context, started time.Time, err error) {
+	finished := time.Now()
+	if ctx != nil && ctx.Skipped {
+		runsTotal.Inc(job, "skipped")
+		return
+	}
+	lastRunDuration.Set(finished.Sub(started).Seconds(), job)
+	lastRunTime.Set(float64(finished.Unix()), job)
+	if err != nil {
+		runsTotal.Inc(job, storage.RUN_FAILED)
+		lastRunSuccess.Set(0, job)
+	} else {
+		runsTotal.Inc(job, storage.RUN_SUCCEEDED)
+		lastRunSuccess.Set(1, job)
+		lastSuccessTime.Set(float64(finished.Unix()), job)
+	}
+	if ctx == nil {
+		return
+	}
+	// What did complete counts even when the run failed
+	if ctx.Manifest != nil {
+		for _, entry := range ctx.Manifest.Objects {
+			recordsArchived.Add(float64(entry.Records), job, entry.Project, entry.Type)
+			bytesUploaded.Add(float64(entry.Bytes), job, entry.Project, entry.Type)
+		}
+		duplicates.Add(float64(ctx.Manifest.Duplicates), job)
+	}
+	if ctx.RunData != nil && ctx.RunData.State != nil {
+		// Alert rules take time() from these, so they keep growing in a
+		// textfile that's no longer being rewritten
+		for _, p := range ctx.RunData.State.Projects {
+			if p.Faults > 0 {
+				lastBackupTime.Set(float64(p.Faults), job, p.Name, "faults")
+			}
+			if p.Notices > 0 {
+				lastBackupTime.Set(float64(p.Notices), job, p.Name, "notices")
+			}
+		}
+	}
+}
+
+// Write the metrics to the node_exporter textfile, if there is one. A failure
+// is logged, as the backup itself still happened
+func writeMetricsTextfile(path string) {
+	if len(path) < 1 {
+		return
+	}
+	if err := registry.WriteTextfile(path); err != nil {
+		log.WithFields(log.Fields{"textfile": path}).Error("Writing metrics: ", err)
+	}
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The content type of the Prometheus text exposition format
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	COUNTER = "counter"
+	GAUGE   = "gauge"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition
+// format, either served over HTTP or to a node_exporter textfile
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*Vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Vec is a metric with a value per combination of its label values
+type Vec struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+	mu     *sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// A counter, which only goes up
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.add(&Vec{Name: name, Help: help, Type: COUNTER, Labels: labels})
+}
+
+// A gauge, which is set to the latest value
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.add(&Vec{Name: name, Help: help, Type: GAUGE, Labels: labels})
+}
+
+func (r *Registry) add(v *Vec) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v.mu = &r.mu
+	v.values = map[string]*sample{}
+	// A metric without labels is written as 0 before it's first set
+	if len(v.Labels) < 1 {
+		v.values[""] = &sample{}
+	}
+	r.metrics = append(r.metrics, v)
+	return v
+}
+
+// Add delta to the value with the label values, given in the order of the labels
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sample(labelValues).value += delta
+}
+
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sample(labelValues).value = value
+}
+
+func (v *Vec) sample(labelValues []string) *sample {
+	if len(labelValues) != len(v.Labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.Name, len(v.Labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labels: append([]string{}, labelValues...)}
+		v.values[key] = s
+	}
+	return s
+}
+
+// Write every metric in the text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b bytes.Buffer
+	for _, v := range r.metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", v.Name, escapeHelp(v.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", v.Name, v.Type)
+		keys := make([]string, 0, len(v.values))
+		for key := range v.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := v.values[key]
+			value := s.value
+			b.WriteString(v.Name)
+			if len(v.Labels) > 0 {
+				b.WriteByte('{')
+				for i, label := range v.Labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label, escapeLabel(s.labels[i]))
+				}
+				b.WriteByte('}')
+			}
+			fmt.Fprintf(&b, " %s\n", formatValue(value))
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Serves the metrics, e.g. on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	r.Write(w)
+}
+
+// Write the metrics to a node_exporter textfile. The file is written next to
+// path then renamed over it, so node_exporter never reads half of it
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := r.Write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Temp files are only readable by their owner, node_exporter may not be
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteTextFormat(t *testing.T) {
+	r := NewRegistry()
+	runs := r.Counter("runs_total", "Runs by status", "backup_job", "status")
+	duration := r.Gauge("run_duration_seconds", "How long the last run took")
+	watermark := r.Gauge("watermark_timestamp_seconds", "How far the backup got", "project")
+
+	runs.Inc("us", "succeeded")
+	runs.Inc("us", "succeeded")
+	runs.Inc("eu \"west\"", "failed")
+	duration.Set(1.5)
+	watermark.Set(1700000000, "Mindful")
+
+	var b bytes.Buffer
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP runs_total Runs by status
+# TYPE runs_total counter
+runs_total{backup_job="eu \"west\"",status="failed"} 1
+runs_total{backup_job="us",status="succeeded"} 2
+# HELP run_duration_seconds How long the last run took
+# TYPE run_duration_seconds gauge
+run_duration_seconds 1.5
+# HELP watermark_timestamp_seconds How far the backup got
+# TYPE watermark_timestamp_seconds gauge
+watermark_timestamp_seconds{project="Mindful"} 1.7e+09
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := NewRegistry()
+	r.Gauge("up", "Whether it's up").Set(1)
+	path := filepath.Join(dir, "honeybadger-s3.prom")
+	if err := r.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "# HELP up Whether it's up\n# TYPE up gauge\nup 1\n" {
+		t.Errorf("Unexpected textfile %q", b)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected the temp file to be renamed but got %d files", len(files))
+	}
+}
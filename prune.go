@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Deletes the objects of the runs that started before cutoff, then their
+// manifests. Only objects listed in a manifest are deleted, so the objects of
+// runs from before manifests were written are left alone
+func prune(ctx *Context, cutoff time.Time, dryRun bool) error {
+	store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Store = store
+
+	manifests, err := ctx.Store.List(storage.ManifestPrefix(ctx.S3prefix))
+	if err != nil {
+		return err
+	}
+	runs, deleted := 0, 0
+	for _, object := range manifests {
+		manifest, err := storage.ReadManifest(ctx.Store, object.Key)
+		if err != nil {
+			return fmt.Errorf("reading manifest %s: %v", object.Key, err)
+		}
+		if !manifest.RunStart.Before(cutoff) {
+			continue
+		}
+		log.WithFields(log.Fields{"run": manifest.RunId, "objects": len(manifest.Objects), "dry run": dryRun}).Info("Pruning")
+		runs++
+		if dryRun {
+			deleted += len(manifest.Objects)
+			continue
+		}
+		for _, entry := range manifest.Objects {
+			if err := ctx.Store.Delete(entry.Key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		// The manifest goes last, so a prune that fails partway can be run again
+		if err := ctx.Store.Delete(object.Key); err != nil {
+			return err
+		}
+	}
+	log.WithFields(log.Fields{"runs": runs, "objects": deleted, "before": cutoff.Format(time.RFC3339), "dry run": dryRun}).Info("Pruned")
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/file"
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+// Writes a run's object and the manifest listing it
+func writeRun(t *testing.T, store storage.Store, runStart time.Time, key string) string {
+	if err := store.Put(key, []byte("{}\n")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := storage.NewManifest(runStart)
+	manifest.Add("Mindful", 1, "faults", []storage.Completed{{Key: key}})
+	manifestKey := storage.ManifestKey("backups", runStart)
+	if err := manifest.Save(store, manifestKey); err != nil {
+		t.Fatal(err)
+	}
+	return manifestKey
+}
+
+// A file store in a directory that's removed once the test is done
+func newFileStore(t *testing.T) *file.Store {
+	return file.NewStore(t.TempDir(), storage.Output{Format: storage.FormatNDJSON})
+}
+
+func exists(store storage.Store, key string) bool {
+	r, err := store.Read(key)
+	if err != nil {
+		return false
+	}
+	r.Close()
+	return true
+}
+
+func TestPruneOnlyDeletesRunsBeforeTheCutoff(t *testing.T) {
+	store := newFileStore(t)
+	cutoff := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	oldManifest := writeRun(t, store, cutoff.Add(-48*time.Hour), "backups/faults-old.json")
+	newManifest := writeRun(t, store, cutoff.Add(time.Hour), "backups/faults-new.json")
+
+	ctx := &Context{Destination: "file://" + store.Root, S3prefix: "backups"}
+	if err := prune(ctx, cutoff, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{oldManifest, "backups/faults-old.json", newManifest, "backups/faults-new.json"} {
+		if !exists(store, key) {
+			t.Errorf("expected a dry run to leave %s but got it deleted", key)
+		}
+	}
+
+	if err := prune(ctx, cutoff, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{oldManifest, "backups/faults-old.json"} {
+		if exists(store, key) {
+			t.Errorf("expected %s of the run before the cutoff to be deleted but got it left", key)
+		}
+	}
+	for _, key := range []string{newManifest, "backups/faults-new.json"} {
+		if !exists(store, key) {
+			t.Errorf("expected %s of the run after the cutoff to be left but got it deleted", key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Copies the objects listed in the manifest at manifestKey, or when there is
+// no manifest, every object under the prefix, to the destination to. Objects
+// keep their keys. Objects listed in a manifest are checked against their
+// digest before they're copied. The project and record type only copy the
+// objects of a manifest that match
+func restore(ctx *Context, manifestKey, to, project, recordType string) error {
+	store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Store = store
+	target, err := openStore(&Context{Destination: to})
+	if err != nil {
+		return err
+	}
+
+	var entries []storage.ManifestEntry
+	if len(manifestKey) > 0 {
+		manifest, err := storage.ReadManifest(ctx.Store, manifestKey)
+		if err != nil {
+			return fmt.Errorf("reading manifest %s: %v", manifestKey, err)
+		}
+		for _, entry := range manifest.Objects {
+			if (len(project) > 0 && entry.Project != project) || (len(recordType) > 0 && entry.Type != recordType) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	} else {
+		if len(project) > 0 || len(recordType) > 0 {
+			return fmt.Errorf("project and type need a manifest to know what's in each file")
+		}
+		objects, err := ctx.Store.List(ctx.S3prefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if isRunFile(object.Key) {
+				continue
+			}
+			entries = append(entries, storage.ManifestEntry{Completed: storage.Completed{Key: object.Key}})
+		}
+	}
+
+	log.WithFields(log.Fields{"to": to, "objects": len(entries)}).Info("Restoring")
+	var bytes int64
+	for _, entry := range entries {
+		n, err := copyObject(ctx.Store, target, entry)
+		if err != nil {
+			return fmt.Errorf("restoring %s: %v", entry.Key, err)
+		}
+		bytes += n
+	}
+	log.WithFields(log.Fields{"to": to, "objects": len(entries), "bytes": bytes}).Info("Restored")
+	return nil
+}
+
+// Copies the object of a manifest entry, streaming it rather than holding it
+// in memory. It's checked against its digest as it's copied, and only written
+// to the destination if it matches, so a corrupt copy never replaces what's
+// there
+func copyObject(from, to storage.Store, entry storage.ManifestEntry) (int64, error) {
+	r, err := from.Read(entry.Key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	body := &digestReader{r: r, hash: sha256.New()}
+	verify := func() error {
+		if len(entry.SHA256) == 0 {
+			return nil
+		}
+		if digest := hex.EncodeToString(body.hash.Sum(nil)); digest != entry.SHA256 {
+			return fmt.Errorf("sha256 %s, expected %s", digest, entry.SHA256)
+		}
+		return nil
+	}
+	if err := to.PutStream(entry.Key, body, verify); err != nil {
+		return 0, err
+	}
+	return body.n, nil
+}
+
+// Hashes and counts what's read through it
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+func TestCopyObjectChecksTheDigest(t *testing.T) {
+	from, to := newFileStore(t), newFileStore(t)
+	body := []byte("{\"id\":1}\n")
+	if err := from.Put("faults.json", body); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body)
+
+	entry := storage.ManifestEntry{Completed: storage.Completed{Key: "faults.json", SHA256: hex.EncodeToString(sum[:])}}
+	n, err := copyObject(from, to, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(body)) {
+		t.Errorf("expected %d bytes copied but got %d", len(body), n)
+	}
+	if !exists(to, "faults.json") {
+		t.Error("expected the object to be copied")
+	}
+
+	entry = storage.ManifestEntry{Completed: storage.Completed{Key: "notices.json", SHA256: hex.EncodeToString(make([]byte, sha256.Size))}}
+	if err := from.Put("notices.json", body); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyObject(from, to, entry); err == nil {
+		t.Error("expected copying an object that doesn't match its digest to fail")
+	}
+	if exists(to, "notices.json") {
+		t.Error("expected an object that doesn't match its digest not to be written")
+	}
+}
+
+func TestCopyObjectKeepsTheDestinationIfTheSourceIsCorrupt(t *testing.T) {
+	from, to := newFileStore(t), newFileStore(t)
+	body := []byte("{\"id\":1}\n")
+	if err := to.Put("faults.json", body); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Put("faults.json", []byte("{\"id\":2}\n")); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body)
+
+	entry := storage.ManifestEntry{Completed: storage.Completed{Key: "faults.json", SHA256: hex.EncodeToString(sum[:])}}
+	if _, err := copyObject(from, to, entry); err == nil {
+		t.Error("expected copying a corrupt object to fail")
+	}
+	copied, _, err := to.ReadVersion("faults.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(copied) != string(body) {
+		t.Errorf("expected the destination to still hold %q but got %q", body, copied)
+	}
+}
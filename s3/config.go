@@ -1,6 +1,9 @@
 package s3
 
 import (
+	"crypto/tls"
+	"net/http"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -10,12 +13,59 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
+const DEFAULT_REGION = "us-east-1"
+
 var config = &aws.Config{
-	Region: aws.String("us-east-1"),
+	Region: aws.String(DEFAULT_REGION),
 }
 
 var s3conn *s3.S3
 
+// The options s3conn was built with
+var configured Options
+
+// Options for the S3 client. Set them with Configure before the first call
+// to S3()
+type Options struct {
+	Region             string // Defaults to us-east-1
+	Endpoint           string // S3 compatible endpoint e.g. http://localhost:9000 for MinIO
+	ForcePathStyle     bool   // Use bucket names in the path instead of the host
+	InsecureSkipVerify bool   // Don't verify the endpoint's TLS certificate
+}
+
+// Applies the options to the client S3() builds. This discards any client
+// that has already been built with different options, so runs with the same
+// options share a client and its connections
+func Configure(o Options) {
+	if s3conn != nil && o == configured {
+		return
+	}
+	configured = o
+	if len(o.Region) > 0 {
+		config.Region = aws.String(o.Region)
+	} else {
+		config.Region = aws.String(DEFAULT_REGION)
+	}
+	if len(o.Endpoint) > 0 {
+		config.Endpoint = aws.String(o.Endpoint)
+	} else {
+		config.Endpoint = nil
+	}
+	config.S3ForcePathStyle = aws.Bool(o.ForcePathStyle)
+	if o.InsecureSkipVerify {
+		log.Warnln("TLS certificate verification is disabled for S3")
+		config.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	} else {
+		config.HTTPClient = nil
+	}
+	s3conn = nil
+}
+
 func S3() *s3.S3 {
 	if s3conn == nil {
 		// Try the to load the env credentials
@@ -35,7 +85,8 @@ func S3() *s3.S3 {
 			_, err := config.Credentials.Get()
 			if err != nil {
 				log.Warnln("No shared credentials found, trying ec2 role provider")
-				config.Credentials = ec2rolecreds.NewCredentialsWithClient(ec2metadata.New(sess, config))
+				// The metadata service must not use a custom S3 endpoint
+				config.Credentials = ec2rolecreds.NewCredentialsWithClient(ec2metadata.New(sess, aws.NewConfig().WithRegion(*config.Region)))
 				_, err := config.Credentials.Get()
 				if err != nil {
 					log.Warnln("Unable to use ec2 role provder")
@@ -1,13 +1,9 @@
 package s3
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
-	"strconv"
-	"strings"
-	"time"
 
+	"github.com/MasteryConnect/honeybadger-s3/storage"
 	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awsutil"
@@ -23,44 +19,52 @@ type Upload struct {
 	PartNumber     int64
 	HasData        bool // Did we call Upload() at least once
 	Body           *bytes.Buffer
+	Output         storage.Output
+	Records        *storage.Encoder // Frames and compresses records into Body
 	CompletedParts []*s3.CompletedPart
 }
 
-type RunData struct {
-	Bucket            string
-	Key               string
-	Loaded            bool  // S3 data was loaded
-	OverrideTimestamp int64 // Overrides all other timestamps
-	PrevTimestamp     map[string]int64
-	NextTimestamp     map[string]int64
+func NewUpload(bucket, key string, out storage.Output) *Upload {
+	return &Upload{Bucket: bucket, Key: key, Output: out, Body: bytes.NewBuffer([]byte{})}
 }
 
-func NewUpload(bucket, key string) *Upload {
-	return &Upload{Bucket: bucket, Key: key, Body: bytes.NewBuffer([]byte{})}
-}
-
-func NewRunData(bucket, key, lastRun string) *RunData {
-	if lastRun != "" {
-		timestamp, err := time.Parse("20060102150405", lastRun)
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.WithFields(log.Fields{
-			"last run string": lastRun,
-			"last run":        timestamp,
-		}).Debug("run data")
-		return &RunData{Bucket: bucket, Key: key, OverrideTimestamp: timestamp.Unix(), PrevTimestamp: make(map[string]int64), NextTimestamp: make(map[string]int64)}
-	} else {
-		return &RunData{Bucket: bucket, Key: key, PrevTimestamp: make(map[string]int64), NextTimestamp: make(map[string]int64)}
+// Carry on a multipart upload from its last checkpoint. The parts already
+// uploaded stay in the upload, and what was pending is buffered again
+func ResumeUpload(bucket string, out storage.Output, state *storage.UploadState) (*Upload, error) {
+	p := NewUpload(bucket, state.Key, out)
+	p.UploadId = aws.String(state.UploadId)
+	p.HasData = state.HasData
+	for _, part := range state.Parts {
+		p.CompletedParts = append(p.CompletedParts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+		p.PartNumber = part.Number
+	}
+	p.Body.Write(state.Pending)
+	records, err := storage.ResumeEncoder(p.Body, out, state.Encoder)
+	if err != nil {
+		return nil, err
 	}
+	p.Records = records
+	return p, nil
 }
 
 // Create the multipart upload
 func (p *Upload) CreateUpload() error {
+	records, err := storage.NewEncoder(p.Body, p.Output)
+	if err != nil {
+		return err
+	}
+	p.Records = records
+
 	params := &s3.CreateMultipartUploadInput{
 		Bucket:      aws.String(p.Bucket), // Required
 		Key:         aws.String(p.Key),    // Required
-		ContentType: aws.String("application/json"),
+		ContentType: aws.String(p.Output.ContentType()),
+	}
+	if encoding := p.Output.ContentEncoding(); len(encoding) > 0 {
+		params.ContentEncoding = aws.String(encoding)
 	}
 	resp, err := S3().CreateMultipartUpload(params)
 	if err != nil {
@@ -82,17 +86,17 @@ func (p *Upload) CreateUpload() error {
 // be a minimum of 5 MB's in size. The last part, whether that is the only
 // part or the last of many,  can be any size
 func (p *Upload) Upload(hbRecord interface{}) error {
-	b, err := json.Marshal(hbRecord)
+	err := p.Records.Write(hbRecord)
 	if err != nil {
 		return err
 	}
-	p.Body.Write(b)
 	p.HasData = true
 	// S3's multipart upload requires that each part (except for the last part)
 	// be a minimum of 5 MB's in size. The last part, whether that is the only
-	// part or the last of many,  can be any size
+	// part or the last of many,  can be any size. Body holds compressed bytes,
+	// so parts are the size they're stored as
 	if p.Body.Len() >= MIN_BYTES {
-		p.flush()
+		return p.flush()
 	}
 	return err
 }
@@ -126,39 +130,73 @@ func (p *Upload) flush() error {
 	return err
 }
 
-// Complete the multipart upload of honeybadger projects if there is at least
-// one project to upload. Abort the upload if no projects need to be uplaoded
-func (p *Upload) CompleteUpload() (string, error) {
-	if p.HasData {
-		// Write any remaining bytes to S3 before closing the upload. There may be
-		// some left to write if we didn't finish exactly on a 5 MB chunk
-		if p.Body.Len() > 0 {
-			p.flush()
-		}
-		params := &s3.CompleteMultipartUploadInput{
-			Bucket:   aws.String(p.Bucket), // Required
-			Key:      aws.String(p.Key),    // Required
-			UploadId: p.UploadId,           // Required
-			MultipartUpload: &s3.CompletedMultipartUpload{
-				Parts: p.CompletedParts,
-			},
-		}
-		resp, err := S3().CompleteMultipartUpload(params)
+// Record the parts uploaded so far and the bytes not yet uploaded as a part.
+// S3 keeps the parts of an incomplete multipart upload until it's aborted
+func (p *Upload) Checkpoint() (*storage.UploadState, error) {
+	encoder, err := p.Records.Checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	state := &storage.UploadState{
+		Key:      p.Key,
+		HasData:  p.HasData,
+		UploadId: aws.StringValue(p.UploadId),
+		Pending:  append([]byte{}, p.Body.Bytes()...),
+		Encoder:  encoder,
+	}
+	for _, part := range p.CompletedParts {
+		state.Parts = append(state.Parts, storage.Part{Number: aws.Int64Value(part.PartNumber), ETag: aws.StringValue(part.ETag)})
+	}
+	return state, nil
+}
 
-		if err != nil {
-			return p.FileLocation(), err
-		}
-		log.WithFields(log.Fields{
-			"aws_response": awsutil.Prettify(resp),
-		}).Debug("response")
-	} else {
+// Complete the multipart upload of honeybadger records if there is at least
+// one record to upload, returning the object written. Abort the upload if no
+// records need to be uploaded
+func (p *Upload) CompleteUpload() ([]storage.Completed, error) {
+	if !p.HasData {
 		p.AbortUpload()
+		return nil, nil
 	}
-	return p.FileLocation(), nil
+	// Flush the end of the records and whatever the compressor is holding
+	if err := p.Records.Close(); err != nil {
+		return nil, err
+	}
+	// Write any remaining bytes to S3 before closing the upload. There may be
+	// some left to write if we didn't finish exactly on a 5 MB chunk
+	if p.Body.Len() > 0 {
+		if err := p.flush(); err != nil {
+			return nil, err
+		}
+	}
+	params := &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(p.Bucket), // Required
+		Key:      aws.String(p.Key),    // Required
+		UploadId: p.UploadId,           // Required
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: p.CompletedParts,
+		},
+	}
+	resp, err := S3().CompleteMultipartUpload(params)
+
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"aws_response": awsutil.Prettify(resp),
+	}).Debug("response")
+	completed := p.Records.Stats()
+	completed.Key = p.Key
+	completed.Location = p.FileLocation()
+	completed.ETag = aws.StringValue(resp.ETag)
+	return []storage.Completed{completed}, nil
 }
 
-// Complete the multipart upload of honeybadger projects
+// Abort the multipart upload, releasing the encoder's compressor
 func (p *Upload) AbortUpload() {
+	if p.Records != nil {
+		p.Records.Close()
+	}
 	abort(aws.String(p.Bucket), aws.String(p.Key), p.UploadId)
 }
 
@@ -190,8 +228,8 @@ func (p *Upload) FileLocation() string {
 	return p.Bucket + "/" + p.Key
 }
 
-// Clean up any unfinished uploads
-func CleanUpFailedUploads(bucket, prefix string) {
+// Clean up any unfinished uploads, except those to the keys in keep
+func CleanUpFailedUploads(bucket, prefix string, keep map[string]bool) {
 	params := &s3.ListMultipartUploadsInput{
 		Bucket: aws.String(bucket), // Required
 		Prefix: aws.String(prefix),
@@ -200,6 +238,9 @@ func CleanUpFailedUploads(bucket, prefix string) {
 	err := S3().ListMultipartUploadsPages(params,
 		func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
 			for _, u := range page.Uploads {
+				if keep[aws.StringValue(u.Key)] {
+					continue
+				}
 				cleanedCount++
 				abort(page.Bucket, u.Key, u.UploadId)
 			}
@@ -274,113 +315,3 @@ func FindAllFailedUploads() {
 		"total size":  totalSize,
 	}).Info("failed uploads")
 }
-
-// Get the previous timestamp for project with name projectName. This will
-// read in the saved run data from S3 if an override timestamp has not
-// been specified. If the run data has a timestamp for the project, it will
-// be saved to the RunData PrevTimestamp map and returned going forward.
-// If there is no timestamp in the run data, then the default 0 value is
-// saved to the RunData.PrevTimestamp map and retured.
-func (r *RunData) GetPrevTimestamp(projectName string) (ts int64, err error) {
-	projectName = strings.ToLower(projectName)
-	if ts, ok := r.PrevTimestamp[projectName]; ok {
-		return ts, err
-	} else if r.OverrideTimestamp == 0 {
-		if !r.Loaded {
-			// We haven't loaded the run data from S3 before, so load it
-			params := &s3.GetObjectInput{
-				Bucket: aws.String(r.Bucket), // Required
-				Key:    aws.String(r.Key),    // Required
-			}
-			resp, err := S3().GetObject(params)
-			if err != nil {
-				if strings.HasPrefix(err.Error(), "NoSuchKey") {
-					// This is the first time we've tried loading the run data, so it
-					// doesn't exist in S3 yet. All timestamps should default to 0
-					r.PrevTimestamp[projectName] = 0 // default
-				} else { // Otherwise some other error occurred
-					return ts, err
-				}
-			} else {
-				log.WithFields(log.Fields{
-					"aws_response": awsutil.Prettify(resp),
-				}).Debug("response")
-
-				scanner := bufio.NewScanner(resp.Body)
-				// Read each projects timestamp
-				for scanner.Scan() {
-					projectTs := strings.Split(scanner.Text(), ":")
-					if len(projectTs) > 0 { // Ignore empty lines
-						timestamp, _ := strconv.ParseInt(strings.TrimSpace(projectTs[1]), 10, 64)
-						// Clean up project name with ToLower and Trim in case it was
-						// manually edited
-						r.PrevTimestamp[strings.ToLower(strings.TrimSpace(projectTs[0]))] = timestamp
-					}
-				}
-			}
-			r.Loaded = true
-		} else {
-			// We've previously loaded the run data from S3 however a project with
-			// the name projectName did not exist i.e. it's the first time we've
-			// tried backing it up. So use the default 0 timestamp
-			r.PrevTimestamp[projectName] = 0 // default
-		}
-	} else {
-		// An override timestamp was passed in, so use that for all projects
-		r.PrevTimestamp[projectName] = r.OverrideTimestamp
-	}
-	// Get the previous timestamp to return
-	ts = r.PrevTimestamp[projectName]
-	// This is the first time we've called this function for projectName, set
-	// the next timestamp
-	r.NextTimestamp[projectName] = time.Now().Unix()
-
-	log.WithFields(log.Fields{
-		"previous run": time.Unix(ts, 0),
-		"next run":     time.Unix(r.NextTimestamp[projectName], 0),
-	}).Info("run data")
-
-	return ts, err
-}
-
-// Save all of the RunData.NextTimetamp's to S3 for the next run to use
-// The format is:
-// project-name-1:next-timestamp1
-// project-name-2:next-timestamp2
-func (r *RunData) SaveNextRun() error {
-	buf := bytes.NewBufferString("")
-	projectNext := map[string]bool{}
-	for project, nextTs := range r.NextTimestamp {
-		projectNext[project] = true
-		buf.WriteString(project)
-		buf.WriteString(":")
-		buf.WriteString(strconv.FormatInt(nextTs, 10))
-		buf.WriteString("\n")
-	}
-	// Any project whose previous timestamp was found in S3, and never had
-	// GetPrevTimestamp called i.e. wasn't backed up during this run, save back
-	// their timestamp to S3 so it is not lost
-	for project, nextTs := range r.PrevTimestamp {
-		if !projectNext[project] {
-			buf.WriteString(project)
-			buf.WriteString(":")
-			buf.WriteString(strconv.FormatInt(nextTs, 10))
-			buf.WriteString("\n")
-		}
-	}
-	params := &s3.PutObjectInput{
-		Bucket: aws.String(r.Bucket), // Required
-		Key:    aws.String(r.Key),    // Required
-		Body:   bytes.NewReader(buf.Bytes()),
-	}
-	resp, err := S3().PutObject(params)
-	if err != nil {
-		return err
-	}
-
-	log.WithFields(log.Fields{
-		"aws_response": awsutil.Prettify(resp),
-	}).Debug("response")
-
-	return err
-}
@@ -0,0 +1,248 @@
+package s3
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Store backs up to an S3 bucket
+type Store struct {
+	Bucket string
+	Output storage.Output // How records are written to uploads
+}
+
+func NewStore(bucket string, out storage.Output) *Store {
+	return &Store{Bucket: bucket, Output: out}
+}
+
+func (s *Store) NewUpload(key string) storage.Upload {
+	return NewUpload(s.Bucket, key, s.Output)
+}
+
+func (s *Store) ResumeUpload(state *storage.UploadState) (storage.Upload, error) {
+	return ResumeUpload(s.Bucket, s.Output, state)
+}
+
+func (s *Store) Read(key string) (io.ReadCloser, error) {
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+	}
+	resp, err := S3().GetObject(params)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, storage.ErrNotExist
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Read the records in the object at key. Objects in any format or compression
+// can be read, including ones written back to back by older versions
+func (s *Store) Records(key string) (*storage.RecordReader, error) {
+	body, err := s.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewRecordReader(body)
+}
+
+func (s *Store) Put(key string, body []byte) error {
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+		Body:   bytes.NewReader(body),
+	}
+	resp, err := S3().PutObject(params)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"aws_response": awsutil.Prettify(resp),
+	}).Debug("response")
+
+	return err
+}
+
+// Uploads body in parts as it's read, so objects of any size can be written
+// without holding them in memory. Bodies smaller than a part are written with
+// a single put. Either way nothing is written until verify accepts the body:
+// the multipart upload is aborted instead of completed if it doesn't
+func (s *Store) PutStream(key string, body io.Reader, verify func() error) error {
+	part := make([]byte, MIN_BYTES)
+	n, err := io.ReadFull(body, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if verify != nil {
+			if err := verify(); err != nil {
+				return err
+			}
+		}
+		return s.Put(key, part[:n])
+	} else if err != nil {
+		return err
+	}
+
+	created, err := S3().CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+	})
+	if err != nil {
+		return err
+	}
+	parts, err := s.uploadParts(key, created.UploadId, part, body)
+	if err == nil && verify != nil {
+		err = verify()
+	}
+	if err != nil {
+		abort(aws.String(s.Bucket), aws.String(key), created.UploadId)
+		return err
+	}
+	resp, err := S3().CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket), // Required
+		Key:             aws.String(key),      // Required
+		UploadId:        created.UploadId,     // Required
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"aws_response": awsutil.Prettify(resp),
+	}).Debug("response")
+
+	return nil
+}
+
+// Uploads the full part already read and then the rest of body, a part at a
+// time, returning the parts to complete the upload with
+func (s *Store) uploadParts(key string, uploadId *string, part []byte, body io.Reader) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	for n, number := len(part), int64(1); n > 0; number++ {
+		resp, err := S3().UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(s.Bucket), // Required
+			Key:        aws.String(key),      // Required
+			PartNumber: aws.Int64(number),    // Required
+			UploadId:   uploadId,             // Required
+			Body:       bytes.NewReader(part[:n]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int64(number)})
+
+		if n, err = io.ReadFull(body, part); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+func (s *Store) ReadVersion(key string) ([]byte, string, error) {
+	params := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+	}
+	resp, err := S3().GetObject(params)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, "", storage.ErrNotExist
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, aws.StringValue(resp.ETag), err
+}
+
+// Writes the object with If-None-Match: *, which S3 only accepts when there's
+// no object at key
+func (s *Store) PutIfNoneMatch(key string, body []byte) (string, error) {
+	return s.putIf(key, body, "If-None-Match", "*")
+}
+
+// Writes the object with If-Match: version, which S3 only accepts when the
+// object's ETag is still version
+func (s *Store) PutIfMatch(key string, body []byte, version string) (string, error) {
+	return s.putIf(key, body, "If-Match", version)
+}
+
+// The SDK's PutObjectInput predates conditional writes, so the condition is
+// added as a header when the request is built, before it's signed
+func (s *Store) putIf(key string, body []byte, header, value string) (string, error) {
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+		Body:   bytes.NewReader(body),
+	}
+	req, resp := S3().PutObjectRequest(params)
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(header, value)
+	})
+	if err := req.Send(); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && isPreconditionFailed(aerr.Code()) {
+			return "", storage.ErrPreconditionFailed
+		}
+		return "", err
+	}
+	log.WithFields(log.Fields{
+		"aws_response": awsutil.Prettify(resp),
+	}).Debug("response")
+	return aws.StringValue(resp.ETag), nil
+}
+
+// S3 fails a conditional write with 412 Precondition Failed, or 409 when
+// another conditional write to the key is in flight
+func isPreconditionFailed(code string) bool {
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+}
+
+func (s *Store) Delete(key string) error {
+	params := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket), // Required
+		Key:    aws.String(key),      // Required
+	}
+	resp, err := S3().DeleteObject(params)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"aws_response": awsutil.Prettify(resp),
+	}).Debug("response")
+	return nil
+}
+
+func (s *Store) List(prefix string) ([]storage.Object, error) {
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket), // Required
+		Prefix: aws.String(prefix),
+	}
+	var objects []storage.Object
+	err := S3().ListObjectsV2Pages(params,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, o := range page.Contents {
+				objects = append(objects, storage.Object{
+					Key:          aws.StringValue(o.Key),
+					Size:         aws.Int64Value(o.Size),
+					LastModified: aws.TimeValue(o.LastModified),
+				})
+			}
+			return !lastPage
+		})
+	return objects, err
+}
+
+func (s *Store) CleanUpFailedUploads(prefix string, keep map[string]bool) {
+	CleanUpFailedUploads(s.Bucket, prefix, keep)
+}
@@ -0,0 +1,175 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+)
+
+// fakeS3 is the part of S3 the conditional writes use: path-style PUT and GET
+// of whole objects, honouring If-None-Match: * and If-Match like S3 does. It
+// also takes multipart uploads, one at a time, for streamed writes
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   [][]byte // Of the multipart upload in progress
+}
+
+func etag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, exists := f.objects[r.URL.Path]
+	query := r.URL.Query()
+	switch {
+	case r.Method == "POST" && len(query["uploads"]) > 0:
+		f.parts = [][]byte{}
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == "PUT" && len(query.Get("partNumber")) > 0:
+		part, _ := ioutil.ReadAll(r.Body)
+		f.parts = append(f.parts, part)
+		w.Header().Set("ETag", etag(part))
+	case r.Method == "POST" && len(query.Get("uploadId")) > 0:
+		f.objects[r.URL.Path] = bytes.Join(f.parts, nil)
+		f.parts = nil
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><ETag>%s</ETag></CompleteMultipartUploadResult>", etag(f.objects[r.URL.Path]))
+	case r.Method == "DELETE" && len(query.Get("uploadId")) > 0:
+		f.parts = nil
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			f.fail(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); len(match) > 0 && (!exists || match != etag(current)) {
+			f.fail(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.objects[r.URL.Path] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "GET":
+		if !exists {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(current))
+		w.Write(current)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	Configure(Options{Endpoint: server.URL, ForcePathStyle: true})
+	return NewStore("backups", storage.Output{Format: storage.FormatNDJSON}), server.Close
+}
+
+func TestConditionalWrites(t *testing.T) {
+	store, done := newTestStore(t)
+	defer done()
+
+	version, err := store.PutIfNoneMatch("lock", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutIfNoneMatch("lock", []byte("second")); err != storage.ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed creating an object that exists but got %v", err)
+	}
+	if _, err := store.PutIfMatch("lock", []byte("second"), `"stale"`); err != storage.ErrPreconditionFailed {
+		t.Errorf("expected ErrPreconditionFailed replacing a changed object but got %v", err)
+	}
+	if _, err := store.PutIfMatch("lock", []byte("second"), version); err != nil {
+		t.Errorf("expected replacing the object at its version to succeed but got %v", err)
+	}
+	body, _, err := store.ReadVersion("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "second" {
+		t.Errorf("expected second but got %s", body)
+	}
+}
+
+func TestLockIsExclusive(t *testing.T) {
+	store, done := newTestStore(t)
+	defer done()
+
+	first := storage.NewLock(store, "prefix/honeybadger-s3.lock", "1", time.Minute)
+	if err := first.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	second := storage.NewLock(store, "prefix/honeybadger-s3.lock", "2", time.Minute)
+	if err := second.Acquire(); err != storage.ErrLocked {
+		t.Fatalf("expected ErrLocked while the first run holds the lock but got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Acquire(); err != nil {
+		t.Fatalf("expected the lock once it was released but got %v", err)
+	}
+	second.Release()
+	if body, _, _ := store.ReadVersion("prefix/honeybadger-s3.lock"); !strings.Contains(string(body), "released_at") {
+		t.Errorf("expected the lock to be released but got %s", body)
+	}
+}
+
+func TestPutStreamOnlyWritesVerifiedBodies(t *testing.T) {
+	store, done := newTestStore(t)
+	defer done()
+
+	rejected := errors.New("rejected")
+	if err := store.PutStream("faults.json", strings.NewReader("corrupt"), func() error { return rejected }); err != rejected {
+		t.Errorf("expected the verify error but got %v", err)
+	}
+	if _, _, err := store.ReadVersion("faults.json"); err != storage.ErrNotExist {
+		t.Errorf("expected nothing written for a rejected body but got %v", err)
+	}
+	if err := store.PutStream("faults.json", strings.NewReader("good"), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if body, _, _ := store.ReadVersion("faults.json"); string(body) != "good" {
+		t.Errorf("expected good but got %s", body)
+	}
+
+	// Bodies of more than a part go up as a multipart upload
+	large := bytes.Repeat([]byte("x"), MIN_BYTES+1)
+	if err := store.PutStream("notices.json", bytes.NewReader(large), func() error { return rejected }); err != rejected {
+		t.Errorf("expected the verify error but got %v", err)
+	}
+	if _, _, err := store.ReadVersion("notices.json"); err != storage.ErrNotExist {
+		t.Errorf("expected nothing written for a rejected multipart body but got %v", err)
+	}
+	if err := store.PutStream("notices.json", bytes.NewReader(large), nil); err != nil {
+		t.Fatal(err)
+	}
+	if body, _, _ := store.ReadVersion("notices.json"); !bytes.Equal(body, large) {
+		t.Errorf("expected %d bytes but got %d", len(large), len(body))
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// Returned when an upload can't be checkpointed, e.g. Parquet uploads
+var ErrNotResumable = errors.New("upload can't be resumed")
+
+// UploadState is what a restarted run needs to carry on an upload from its
+// last checkpoint
+type UploadState struct {
+	Key         string         `json:"key"`
+	HasData     bool           `json:"has_data"`
+	UploadId    string         `json:"upload_id,omitempty"`   // The S3 multipart upload
+	Parts       []Part         `json:"parts,omitempty"`       // The parts of the multipart upload already uploaded
+	Pending     []byte         `json:"pending,omitempty"`     // Written but not yet uploaded as a part
+	PendingKey  string         `json:"pending_key,omitempty"` // The object Pending was saved to instead
+	Encoder     EncoderState   `json:"encoder"`
+	Partitioned bool           `json:"partitioned,omitempty"`
+	Partitions  []*UploadState `json:"partitions,omitempty"` // The upload of each open partition of a partitioned upload
+	Partition   string         `json:"partition,omitempty"`  // The partition this is the upload of, the key of its first object
+	Closed      []Completed    `json:"closed,omitempty"`     // The objects of partitions already completed
+	Objects     map[string]int `json:"objects,omitempty"`    // How many objects each partition has been written to
+}
+
+// Part is an uploaded part of an S3 multipart upload
+type Part struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+// EncoderState is how far an Encoder got, for the manifest and for carrying on
+// the object's digest
+type EncoderState struct {
+	Records int64     `json:"records"`
+	Bytes   int64     `json:"bytes"`
+	Digest  []byte    `json:"digest"` // The state of the SHA-256 of the object
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+}
+
+// The keys of the objects being uploaded
+func (s *UploadState) Keys() []string {
+	if !s.Partitioned {
+		return []string{s.Key}
+	}
+	var keys []string
+	for _, p := range s.Partitions {
+		keys = append(keys, p.Keys()...)
+	}
+	return keys
+}
+
+// Move the pending bytes of the upload, and of each of its partitions, to
+// objects under prefix so the checkpoint itself stays small. The objects are
+// named by their digest, so those in saved, e.g. by the previous checkpoint,
+// aren't written again. Returns the keys of the objects the state refers to
+func (s *UploadState) SavePending(store Store, prefix string, saved map[string]bool) (map[string]bool, error) {
+	keys := map[string]bool{}
+	for _, state := range s.states() {
+		if len(state.Pending) < 1 {
+			if len(state.PendingKey) > 0 {
+				keys[state.PendingKey] = true
+			}
+			continue
+		}
+		digest := sha256.Sum256(state.Pending)
+		key := prefix + "/" + hex.EncodeToString(digest[:])
+		if !saved[key] {
+			if err := store.Put(key, state.Pending); err != nil {
+				return nil, err
+			}
+		}
+		state.Pending, state.PendingKey = nil, key
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// Read back the pending bytes SavePending moved out of the state
+func (s *UploadState) ReadPending(store Store) error {
+	for _, state := range s.states() {
+		if len(state.PendingKey) < 1 {
+			continue
+		}
+		body, err := store.Read(state.PendingKey)
+		if err != nil {
+			return err
+		}
+		state.Pending, err = ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The state and the states of its partitions
+func (s *UploadState) states() []*UploadState {
+	states := []*UploadState{s}
+	for _, p := range s.Partitions {
+		states = append(states, p.states()...)
+	}
+	return states
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPendingBytesAreSavedOutsideTheCheckpoint(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{}}
+	state := &UploadState{Partitioned: true, Partitions: []*UploadState{
+		{Key: "dt=2024-01-01", Pending: []byte("first")},
+		{Key: "dt=2024-01-02", Pending: []byte("second")},
+	}}
+	keys, err := state.SavePending(store, "checkpoints/1/pending", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || len(store.objects) != 2 {
+		t.Fatalf("expected the pending bytes of both partitions saved but got %v", keys)
+	}
+	for _, p := range state.Partitions {
+		if len(p.Pending) > 0 || !keys[p.PendingKey] {
+			t.Errorf("expected %s to refer to its pending bytes but got %q %q", p.Key, p.Pending, p.PendingKey)
+		}
+	}
+
+	// Pending bytes that haven't changed aren't written again
+	store.objects = map[string][]byte{}
+	unchanged := &UploadState{Key: "dt=2024-01-01", Pending: []byte("first")}
+	if _, err := unchanged.SavePending(store, "checkpoints/1/pending", keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("expected nothing written but got %v", store.objects)
+	}
+
+	store.objects[unchanged.PendingKey] = []byte("first")
+	if err := unchanged.ReadPending(store); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unchanged.Pending, []byte("first")) {
+		t.Errorf("expected the pending bytes read back but got %q", unchanged.Pending)
+	}
+}
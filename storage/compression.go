@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is how objects are compressed as records are written to them
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+func ParseCompression(compression string) (Compression, error) {
+	switch Compression(compression) {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return Compression(compression), nil
+	}
+	return "", fmt.Errorf("unknown compression %q, expected none, gzip or zstd", compression)
+}
+
+// The extension added to keys of objects with this compression
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// The Content-Encoding of objects with this compression
+func (c Compression) ContentEncoding() string {
+	switch c {
+	case CompressionGzip, CompressionZstd:
+		return string(c)
+	}
+	return ""
+}
+
+// Returns a writer that compresses everything written to it into w. Close it
+// to flush the compressed data, it doesn't close w
+func (c Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// Returns a reader that decompresses body, working out its compression from
+// its first few bytes. Uncompressed bodies are read as they are
+func decompress(body io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(body)
+	magic, err := buf.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buf)
+	case bytes.HasPrefix(magic, zstdMagic):
+		dec, err := zstd.NewReader(buf)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// Output is how records are written to each object
+type Output struct {
+	Format       Format
+	Compression  Compression
+	RowGroupSize int // Rows per Parquet row group
+}
+
+// Writes records in one of the formats
+type recordWriter interface {
+	Write(record interface{}) error
+	Close() error
+}
+
+// The extension of keys of objects written with this output e.g. .json.gz
+func (o Output) Extension() string {
+	if o.Format == FormatParquet {
+		return ".parquet"
+	}
+	return ".json" + o.Compression.Extension()
+}
+
+// The Content-Type of objects written with this output
+func (o Output) ContentType() string {
+	if o.Format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "application/json"
+}
+
+// The Content-Encoding of objects written with this output. Parquet objects
+// have none as Parquet compresses each column itself
+func (o Output) ContentEncoding() string {
+	if o.Format == FormatParquet {
+		return ""
+	}
+	return o.Compression.ContentEncoding()
+}
+
+// Encoder frames records according to the output format and compresses them
+// as they're written to w. It keeps count of what's written for the manifest
+type Encoder struct {
+	out        Output
+	records    recordWriter
+	text       *RecordWriter // The records when they're JSON rather than Parquet
+	compressor io.WriteCloser
+	digest     *digestWriter
+	stats      Completed
+	dirty      bool // Records were written since the compressor was started
+	closed     bool
+}
+
+// Counts and hashes the bytes written through it
+type digestWriter struct {
+	w     io.Writer
+	hash  hash.Hash
+	bytes int64
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.bytes += int64(n)
+	return n, err
+}
+
+func NewEncoder(w io.Writer, out Output) (*Encoder, error) {
+	digest := &digestWriter{w: w, hash: sha256.New()}
+	if out.Format == FormatParquet {
+		return &Encoder{out: out, records: NewParquetWriter(digest, out.Compression, out.RowGroupSize), compressor: nopWriteCloser{digest}, digest: digest}, nil
+	}
+	compressor, err := out.Compression.NewWriter(digest)
+	if err != nil {
+		return nil, err
+	}
+	text := NewRecordWriter(compressor, out.Format)
+	return &Encoder{out: out, records: text, text: text, compressor: compressor, digest: digest}, err
+}
+
+// Carry on encoding to w, which already holds what was written up to state
+func ResumeEncoder(w io.Writer, out Output, state EncoderState) (*Encoder, error) {
+	if out.Format == FormatParquet {
+		return nil, ErrNotResumable
+	}
+	e, err := NewEncoder(w, out)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.digest.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state.Digest); err != nil {
+		return nil, err
+	}
+	e.digest.bytes = state.Bytes
+	e.text.count = int(state.Records)
+	e.stats.Records, e.stats.MinTime, e.stats.MaxTime = state.Records, state.MinTime, state.MaxTime
+	return e, nil
+}
+
+// End the compressed stream here, so everything written so far is in w, and
+// return the state to resume from. Writing carries on in a new stream, e.g. a
+// new gzip member, which readers decompress as if it were one. Parquet can't
+// be resumed as its footer is only written at the end
+func (e *Encoder) Checkpoint() (EncoderState, error) {
+	if e.text == nil {
+		return EncoderState{}, ErrNotResumable
+	}
+	if e.dirty {
+		if err := e.compressor.Close(); err != nil {
+			return EncoderState{}, err
+		}
+		compressor, err := e.out.Compression.NewWriter(e.digest)
+		if err != nil {
+			return EncoderState{}, err
+		}
+		e.compressor, e.text.w, e.dirty = compressor, compressor, false
+	}
+	digest, err := e.digest.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return EncoderState{}, err
+	}
+	return EncoderState{
+		Records: e.stats.Records,
+		Bytes:   e.digest.bytes,
+		Digest:  digest,
+		MinTime: e.stats.MinTime,
+		MaxTime: e.stats.MaxTime,
+	}, nil
+}
+
+// Write a single record
+func (e *Encoder) Write(record interface{}) error {
+	if err := e.records.Write(record); err != nil {
+		return err
+	}
+	e.stats.Records++
+	e.dirty = true
+	if r, ok := record.(Timestamped); ok {
+		if t := r.Timestamp(); !t.IsZero() {
+			if e.stats.MinTime.IsZero() || t.Before(e.stats.MinTime) {
+				e.stats.MinTime = t
+			}
+			if t.After(e.stats.MaxTime) {
+				e.stats.MaxTime = t
+			}
+		}
+	}
+	return nil
+}
+
+// What's been written so far. The size and digest are only final once the
+// encoder is closed
+func (e *Encoder) Stats() Completed {
+	stats := e.stats
+	stats.Bytes = e.digest.bytes
+	stats.SHA256 = hex.EncodeToString(e.digest.hash.Sum(nil))
+	return stats
+}
+
+// End the records and flush everything still held by the compressor to w,
+// releasing the compressor. It doesn't close w. Closing it again does nothing
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	err := e.records.Close()
+	if closeErr := e.compressor.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
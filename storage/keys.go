@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variables a key template can use
+const (
+	KEY_PREFIX        = "prefix"        // The S3 directory
+	KEY_ENV           = "env"           // The configured environment
+	KEY_TYPE          = "type"          // The record type, projects, faults or notices
+	KEY_PROJECT       = "project"       // The project name, with spaces replaced by underscores
+	KEY_PROJECT_LOWER = "project_lower" // The project name in lowercase, with spaces replaced by underscores
+	KEY_PROJECT_ID    = "project_id"    // The project ID
+	KEY_RUN_ID        = "run_id"        // When the run started e.g. 20240101120000
+	KEY_RUN_TIME      = "run_time"      // When the run started in UTC e.g. 2024-01-01T12:00:00Z
+	KEY_DATE          = "date"          // The record's date in UTC e.g. 2024-01-01
+	KEY_YEAR          = "yyyy"          // The record's year in UTC
+	KEY_MONTH         = "mm"            // The record's month in UTC
+	KEY_DAY           = "dd"            // The record's day in UTC
+	KEY_HOUR          = "hh"            // The record's hour in UTC
+	KEY_EXT           = "ext"           // The file extension e.g. .json.gz
+)
+
+// How run IDs are formatted, from when the run started
+const RUN_ID_FORMAT = "20060102150405"
+
+// Variables taken from each record's timestamp rather than the run. Templates
+// using them write one object per partition
+var recordTimeVariables = map[string]bool{
+	KEY_DATE:  true,
+	KEY_YEAR:  true,
+	KEY_MONTH: true,
+	KEY_DAY:   true,
+	KEY_HOUR:  true,
+}
+
+// Variables of the project, which are empty for the projects file so have to
+// be in an optional section
+var projectVariables = map[string]bool{
+	KEY_PROJECT:       true,
+	KEY_PROJECT_LOWER: true,
+	KEY_PROJECT_ID:    true,
+}
+
+var keyVariables = map[string]bool{
+	KEY_PREFIX:        true,
+	KEY_ENV:           true,
+	KEY_TYPE:          true,
+	KEY_PROJECT:       true,
+	KEY_PROJECT_LOWER: true,
+	KEY_PROJECT_ID:    true,
+	KEY_RUN_ID:        true,
+	KEY_RUN_TIME:      true,
+	KEY_DATE:          true,
+	KEY_YEAR:          true,
+	KEY_MONTH:         true,
+	KEY_DAY:           true,
+	KEY_HOUR:          true,
+	KEY_EXT:           true,
+}
+
+// KeyTemplate names the objects a run writes. Variables are written in braces
+// e.g. {project}, and a section in square brackets is left out when any
+// variable in it is empty, e.g. the projects have no project so
+// "[{project}-]{type}" becomes "projects"
+type KeyTemplate struct {
+	Template  string
+	segments  []keySegment
+	variables map[string]bool
+}
+
+// Either literal text, a variable or an optional section
+type keySegment struct {
+	literal  string
+	variable string
+	optional []keySegment
+}
+
+// KeyVars are the values of a stream's variables, everything but the record
+// time
+type KeyVars struct {
+	Prefix      string
+	Environment string
+	Type        string
+	Project     string
+	ProjectId   int
+	RunStart    time.Time
+	Extension   string
+}
+
+// Parses and validates a key template. Every stream of a run must get its own
+// keys, as must every run, so the template has to use the record type, the
+// project name or ID, and the run ID or time. The project is in an optional
+// section, as the projects file has none
+func ParseKeyTemplate(template string) (*KeyTemplate, error) {
+	k := &KeyTemplate{Template: template, variables: map[string]bool{}}
+	segments, _, err := k.parse(template, false)
+	if err != nil {
+		return nil, err
+	}
+	k.segments = segments
+	if !k.variables[KEY_TYPE] {
+		return nil, fmt.Errorf("key template %q must use {%s}", template, KEY_TYPE)
+	}
+	if !k.variables[KEY_PROJECT] && !k.variables[KEY_PROJECT_LOWER] && !k.variables[KEY_PROJECT_ID] {
+		return nil, fmt.Errorf("key template %q must use {%s}, {%s} or {%s}", template, KEY_PROJECT, KEY_PROJECT_LOWER, KEY_PROJECT_ID)
+	}
+	if !k.variables[KEY_RUN_ID] && !k.variables[KEY_RUN_TIME] {
+		return nil, fmt.Errorf("key template %q must use {%s} or {%s}", template, KEY_RUN_ID, KEY_RUN_TIME)
+	}
+	return k, nil
+}
+
+// Parses segments up to the end of the template, or the end of the optional
+// section when optional. Returns what's left after the section
+func (k *KeyTemplate) parse(s string, optional bool) ([]keySegment, string, error) {
+	var segments []keySegment
+	for len(s) > 0 {
+		i := strings.IndexAny(s, "{[]")
+		if i < 0 {
+			segments = append(segments, keySegment{literal: s})
+			break
+		}
+		if i > 0 {
+			segments = append(segments, keySegment{literal: s[:i]})
+		}
+		switch s[i] {
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, "", fmt.Errorf("key template %q has an unmatched {", k.Template)
+			}
+			name := s[i+1 : i+end]
+			if !keyVariables[name] {
+				return nil, "", fmt.Errorf("key template %q has an unknown variable {%s}", k.Template, name)
+			}
+			if projectVariables[name] && !optional {
+				return nil, "", fmt.Errorf("key template %q must have {%s} in square brackets, the projects file has no project", k.Template, name)
+			}
+			k.variables[name] = true
+			segments = append(segments, keySegment{variable: name})
+			s = s[i+end+1:]
+		case '[':
+			if optional {
+				return nil, "", fmt.Errorf("key template %q has a nested [", k.Template)
+			}
+			inner, rest, err := k.parse(s[i+1:], true)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(rest) < 1 {
+				return nil, "", fmt.Errorf("key template %q has an unmatched [", k.Template)
+			}
+			segments = append(segments, keySegment{optional: inner})
+			s = rest[1:]
+		case ']':
+			if !optional {
+				return nil, "", fmt.Errorf("key template %q has an unmatched ]", k.Template)
+			}
+			return segments, s[i:], nil
+		}
+	}
+	if optional {
+		return nil, "", fmt.Errorf("key template %q has an unmatched [", k.Template)
+	}
+	return segments, "", nil
+}
+
+// Whether keys depend on the record time, so a stream is split into one object
+// per partition
+func (k *KeyTemplate) Partitioned() bool {
+	for name := range recordTimeVariables {
+		if k.variables[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the key of a record from time t in the stream
+func (k *KeyTemplate) Key(vars KeyVars, t time.Time) string {
+	key, _ := render(k.segments, vars, t.UTC())
+	return key
+}
+
+// Renders segments, and whether all the variables in them had a value
+func render(segments []keySegment, vars KeyVars, t time.Time) (string, bool) {
+	var b strings.Builder
+	complete := true
+	for _, segment := range segments {
+		switch {
+		case segment.optional != nil:
+			if s, ok := render(segment.optional, vars, t); ok {
+				b.WriteString(s)
+			}
+		case len(segment.variable) > 0:
+			value := vars.value(segment.variable, t)
+			if len(value) < 1 {
+				complete = false
+			}
+			b.WriteString(value)
+		default:
+			b.WriteString(segment.literal)
+		}
+	}
+	return b.String(), complete
+}
+
+func (vars KeyVars) value(name string, t time.Time) string {
+	switch name {
+	case KEY_PREFIX:
+		return strings.Trim(vars.Prefix, "/")
+	case KEY_ENV:
+		return keySafe(vars.Environment)
+	case KEY_TYPE:
+		return vars.Type
+	case KEY_PROJECT:
+		return keySafe(vars.Project)
+	case KEY_PROJECT_LOWER:
+		return keySafe(strings.ToLower(vars.Project))
+	case KEY_PROJECT_ID:
+		if vars.ProjectId == 0 {
+			return ""
+		}
+		return strconv.Itoa(vars.ProjectId)
+	case KEY_RUN_ID:
+		return vars.RunStart.Format(RUN_ID_FORMAT)
+	case KEY_RUN_TIME:
+		return vars.RunStart.UTC().Format(time.RFC3339)
+	case KEY_DATE:
+		return t.Format("2006-01-02")
+	case KEY_YEAR:
+		return t.Format("2006")
+	case KEY_MONTH:
+		return t.Format("01")
+	case KEY_DAY:
+		return t.Format("02")
+	case KEY_HOUR:
+		return t.Format("15")
+	case KEY_EXT:
+		return vars.Extension
+	}
+	return ""
+}
+
+// Joins the parts of a key with slashes, leaving out empty parts, so the keys
+// under an empty prefix don't start with a slash
+func JoinKey(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); len(part) > 0 {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// Replaces spaces with underscores, as keys always have, and percent-encodes
+// slashes so a name isn't split across directories
+func keySafe(name string) string {
+	return strings.NewReplacer(" ", "_", "/", "%2F", "%", "%25").Replace(name)
+}
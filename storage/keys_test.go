@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	runStart := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	recordTime := time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC)
+	notices := KeyVars{Prefix: "backups/", Environment: "prod", Type: "notices", Project: "My Project", ProjectId: 42, RunStart: runStart, Extension: ".json.gz"}
+	projects := KeyVars{Prefix: "backups", Type: "projects", RunStart: runStart, Extension: ".json"}
+
+	tests := []struct {
+		template string
+		vars     KeyVars
+		expected string
+	}{
+		{"[{prefix}/][{project}-]{type}-{run_id}{ext}", notices, "backups/My_Project-notices-20240102030405.json.gz"},
+		{"[{prefix}/]type={type}/[project={project_lower}/]dt={date}/hour={hh}/{run_id}{ext}", notices, "backups/type=notices/project=my_project/dt=2023-12-31/hour=23/20240102030405.json.gz"},
+		{"[{prefix}/][{project}-]{type}-{run_id}{ext}", projects, "backups/projects-20240102030405.json"},
+		{"[{env}/][{project_id}/]{type}/{yyyy}/{mm}/{dd}/{hh}/{run_time}{ext}", notices, "prod/42/notices/2023/12/31/23/2024-01-02T03:04:05Z.json.gz"},
+		{"[{env}/][{project_id}/]{type}/{date}/{run_id}{ext}", projects, "projects/2023-12-31/20240102030405.json"},
+	}
+	for _, test := range tests {
+		k, err := ParseKeyTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := k.Key(test.vars, recordTime); key != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, key)
+		}
+	}
+}
+
+func TestKeyTemplateMustNotCollide(t *testing.T) {
+	invalid := []string{
+		"{prefix}/[{project}-]{run_id}{ext}",      // Faults and notices would collide
+		"{prefix}/{type}-{run_id}{ext}",           // Projects would collide
+		"{prefix}/[{project}-]{type}{ext}",        // Runs would collide
+		"{prefix}/{project}-{type}-{run_id}{ext}", // The projects file would have an empty name
+		"{prefix}/[{project}-]{type}-{run}{ext}",  // Unknown variable
+		"{prefix}/[{project}-{type}-{run_id}",     // Unmatched [
+		"{prefix}/[{project}]]-{type}-{run_id}",   // Unmatched ]
+		"{prefix}/[{project}-]{type}-{run_id{ext", // Unmatched {
+	}
+	for _, template := range invalid {
+		if _, err := ParseKeyTemplate(template); err == nil {
+			t.Errorf("expected %q to be invalid", template)
+		}
+	}
+}
+
+func TestDifferentProjectsHaveDifferentKeys(t *testing.T) {
+	template, err := ParseKeyTemplate("[{project}/]{type}-{run_id}{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{}
+	for _, name := range []string{"My Project", "My/Project", "My%2FProject", "My Project/"} {
+		key := template.Key(KeyVars{Project: name, Type: "faults", RunStart: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, time.Time{})
+		if strings.Count(key, "/") != 1 {
+			t.Errorf("expected %q to stay in one directory but got %s", name, key)
+		}
+		if other, ok := keys[key]; ok {
+			t.Errorf("expected %q and %q to have different keys but both got %s", name, other, key)
+		}
+		keys[key] = name
+	}
+}
+
+func TestJoinKeyLeavesOutEmptyParts(t *testing.T) {
+	for _, test := range []struct {
+		parts    []string
+		expected string
+	}{
+		{[]string{"", "checkpoints", "1.json"}, "checkpoints/1.json"},
+		{[]string{"backups/", "/index", "1"}, "backups/index/1"},
+		{[]string{"", ""}, ""},
+	} {
+		if key := JoinKey(test.parts...); key != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, key)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// How long a lock is held for without a heartbeat, unless configured
+const DEFAULT_LOCK_LEASE = 10 * time.Minute
+
+// Returned by Lock.Acquire while another run holds the lock
+var ErrLocked = errors.New("another run holds the lock")
+
+// Lease is the body of a lock object, who holds the lock and until when
+type Lease struct {
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ReleasedAt time.Time `json:"released_at,omitempty"`
+}
+
+// Whether the lease no longer holds the lock at time t
+func (l *Lease) Expired(t time.Time) bool {
+	return !l.ReleasedAt.IsZero() || !t.Before(l.ExpiresAt)
+}
+
+// Lock is a lease on an object in the store so that only one run backs up to
+// a prefix at a time. It's taken with a conditional write, kept by a
+// heartbeat that extends the lease, and can be taken over by another run once
+// the lease expires, e.g. when the run holding it crashed
+type Lock struct {
+	Store   Store
+	Key     string
+	Owner   string
+	Lease   time.Duration
+	lease   Lease
+	version string // The version of the lock object we last wrote
+	mu      sync.Mutex
+	err     error // Why the lock was lost, if it was
+	stop    chan struct{}
+	stopped chan struct{}
+	release sync.Once
+	relErr  error // Why releasing the lock failed, if it did
+}
+
+func NewLock(store Store, key, runId string, lease time.Duration) *Lock {
+	if lease <= 0 {
+		lease = DEFAULT_LOCK_LEASE
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%s", host, os.Getpid(), runId)
+	return &Lock{Store: store, Key: key, Owner: owner, Lease: lease}
+}
+
+// Take the lock and start the heartbeat. Returns ErrLocked if another run
+// holds it
+func (l *Lock) Acquire() error {
+	now := time.Now()
+	l.lease = Lease{Owner: l.Owner, AcquiredAt: now, ExpiresAt: now.Add(l.Lease)}
+	body, err := json.Marshal(l.lease)
+	if err != nil {
+		return err
+	}
+	// Try twice, in case the lock is released between trying to create it and
+	// reading who holds it
+	for attempt := 0; attempt < 2; attempt++ {
+		l.version, err = l.Store.PutIfNoneMatch(l.Key, body)
+		if err != ErrPreconditionFailed {
+			break
+		}
+		var current []byte
+		var version string
+		current, version, err = l.Store.ReadVersion(l.Key)
+		if err == ErrNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+		held := &Lease{}
+		if jsonErr := json.Unmarshal(current, held); jsonErr == nil && !held.Expired(now) {
+			log.WithFields(log.Fields{"lock": l.Key, "owner": held.Owner, "expires": held.ExpiresAt}).Info("Another run holds the lock")
+			return ErrLocked
+		}
+		// The lease expired, take the lock over unless someone else beat us to it
+		l.version, err = l.Store.PutIfMatch(l.Key, body, version)
+		if err == ErrPreconditionFailed {
+			return ErrLocked
+		} else if err == nil {
+			log.WithFields(log.Fields{"lock": l.Key, "previous owner": held.Owner, "expired": held.ExpiresAt}).Warn("Took over an expired lock")
+		}
+		break
+	}
+	if err == ErrPreconditionFailed {
+		return ErrLocked
+	} else if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"lock": l.Key, "owner": l.Owner, "expires": l.lease.ExpiresAt}).Info("Acquired the lock")
+
+	l.stop, l.stopped = make(chan struct{}), make(chan struct{})
+	go l.heartbeat()
+	return nil
+}
+
+// Extend the lease every third of the lease, so a couple of failed heartbeats
+// don't lose the lock. Once the lease runs out without being extended the lock
+// is lost, as another run may take it over
+func (l *Lock) heartbeat() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if lost := l.Err(); lost != nil {
+				log.WithFields(log.Fields{"lock": l.Key}).Error(lost)
+				return
+			}
+			if err := l.renew(); err == ErrPreconditionFailed {
+				l.mu.Lock()
+				l.err = fmt.Errorf("lost the lock %s to another run", l.Key)
+				l.mu.Unlock()
+				log.WithFields(log.Fields{"lock": l.Key}).Error(l.Err())
+				return
+			} else if err != nil {
+				log.WithFields(log.Fields{"lock": l.Key, "expires": l.expiresAt()}).Warn("Failed to extend the lease: ", err)
+				if lost := l.Err(); lost != nil {
+					log.WithFields(log.Fields{"lock": l.Key}).Error(lost)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *Lock) expiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lease.ExpiresAt
+}
+
+func (l *Lock) renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease := l.lease
+	lease.ExpiresAt = time.Now().Add(l.Lease)
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	version, err := l.Store.PutIfMatch(l.Key, body, l.version)
+	if err != nil {
+		return err
+	}
+	l.lease, l.version = lease, version
+	return nil
+}
+
+// Why the lock was lost, or nil while it's still held. It's lost once the
+// last lease written expires, even if no other run has taken it yet
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil && !l.lease.ExpiresAt.IsZero() && !time.Now().Before(l.lease.ExpiresAt) {
+		l.err = fmt.Errorf("the lease on the lock %s expired at %s without being extended", l.Key, l.lease.ExpiresAt.Format(time.RFC3339))
+	}
+	return l.err
+}
+
+// Stop the heartbeat and release the lock, so the next run doesn't have to
+// wait for the lease to expire. Returns why the lock was lost if it was
+func (l *Lock) Release() error {
+	// It may be released both by the run and by a daemon that's stopped
+	// before the run finishes
+	l.release.Do(func() {
+		l.relErr = l.releaseLease()
+	})
+	return l.relErr
+}
+
+func (l *Lock) releaseLease() error {
+	close(l.stop)
+	<-l.stopped
+	if err := l.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lease := l.lease
+	lease.ReleasedAt = time.Now()
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	_, err = l.Store.PutIfMatch(l.Key, body, l.version)
+	if err == nil {
+		log.WithFields(log.Fields{"lock": l.Key}).Info("Released the lock")
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// A store that creates the lock but then can't be reached to extend it
+type unreachableStore struct {
+	Store
+}
+
+func (s *unreachableStore) PutIfNoneMatch(key string, body []byte) (string, error) {
+	return "1", nil
+}
+
+func (s *unreachableStore) PutIfMatch(key string, body []byte, version string) (string, error) {
+	return "", errors.New("store is unreachable")
+}
+
+func TestLockIsLostWhenTheLeaseExpires(t *testing.T) {
+	lock := NewLock(&unreachableStore{}, "honeybadger-s3.lock", "1", 30*time.Millisecond)
+	if err := lock.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Err(); err != nil {
+		t.Fatalf("expected the lock to be held but got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := lock.Err(); err == nil {
+		t.Error("expected the lock to be lost once the lease expired without being extended")
+	}
+	if err := lock.Release(); err == nil {
+		t.Error("expected releasing a lost lock to fail")
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// Manifest lists every object a run completed, so downstream jobs can tell
+// exactly what the run produced
+type Manifest struct {
+	RunId      string          `json:"run_id"`
+	RunStart   time.Time       `json:"run_start"`
+	RunEnd     time.Time       `json:"run_end"`
+	Error      string          `json:"error,omitempty"`       // Why the run stopped early, if it did
+	Duplicates int             `json:"duplicates_suppressed"` // Notices not archived again as an earlier run had
+	Objects    []ManifestEntry `json:"objects"`
+	Pending    []ManifestEntry `json:"pending,omitempty"` // Objects completed early by uploads that aren't done yet
+}
+
+// ManifestEntry is an object and the records in it
+type ManifestEntry struct {
+	Project   string `json:"project,omitempty"`
+	ProjectId int    `json:"project_id,omitempty"`
+	Type      string `json:"type"`
+	Completed
+}
+
+func NewManifest(runStart time.Time) *Manifest {
+	return &Manifest{RunId: runStart.Format(RUN_ID_FORMAT), RunStart: runStart, Objects: []ManifestEntry{}}
+}
+
+// The key of the manifest of the run that started at runStart
+func ManifestKey(prefix string, runStart time.Time) string {
+	return ManifestPrefix(prefix) + runStart.Format(RUN_ID_FORMAT) + ".json"
+}
+
+// The prefix of the keys of the manifests of every run
+func ManifestPrefix(prefix string) string {
+	return JoinKey(prefix, "manifests") + "/"
+}
+
+// Add the objects an upload of a project's records completed. The projects
+// themselves have no project
+func (m *Manifest) Add(project string, projectId int, recordType string, completed []Completed) {
+	for _, c := range completed {
+		m.Objects = append(m.Objects, ManifestEntry{Project: project, ProjectId: projectId, Type: recordType, Completed: c})
+	}
+}
+
+// Add the objects an upload completed before the rest of it, e.g. partitions
+// closed to make room for others. They're pending until the upload is done
+func (m *Manifest) AddPending(project string, projectId int, recordType string, completed []Completed) {
+	for _, c := range completed {
+		m.Pending = append(m.Pending, ManifestEntry{Project: project, ProjectId: projectId, Type: recordType, Completed: c})
+	}
+}
+
+// Forget the pending objects of a project's uploads once they're done. They're
+// in the objects if the uploads completed, and deleted if they were aborted
+func (m *Manifest) ClearPending(projectId int) {
+	var pending []ManifestEntry
+	for _, entry := range m.Pending {
+		if entry.ProjectId != projectId {
+			pending = append(pending, entry)
+		}
+	}
+	m.Pending = pending
+}
+
+// Write the manifest to key
+func (m *Manifest) Save(store Store, key string) error {
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(key, append(body, '\n'))
+}
+
+// Read the manifest at key
+func ReadManifest(store Store, key string) (*Manifest, error) {
+	body, err := store.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	return m, json.Unmarshal(b, m)
+}
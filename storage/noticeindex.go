@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The first bytes of an encoded notice index, and the version of the encoding
+const (
+	NOTICE_INDEX_MAGIC   = "HBNI"
+	NOTICE_INDEX_VERSION = 1
+)
+
+var errNoticeIndexTruncated = errors.New("notice index is truncated")
+
+// NoticeIndex is the IDs of the notices archived of each fault, so that
+// notices already archived aren't archived again, whatever window a run looks
+// at. It's encoded as the faults in ID order, each with the count of its
+// notices then their IDs in order, every ID as a varint of the difference
+// from the one before, which keeps it to a couple of bytes a notice
+type NoticeIndex struct {
+	faults map[int]map[int]bool
+	added  map[int][]int // Notices added since the index was read, by fault ID
+}
+
+func NewNoticeIndex() *NoticeIndex {
+	return &NoticeIndex{faults: map[int]map[int]bool{}, added: map[int][]int{}}
+}
+
+// Read the index at key. There's an empty index when there isn't one yet
+func ReadNoticeIndex(store Store, key string) (*NoticeIndex, error) {
+	body, err := store.Read(key)
+	if err == ErrNotExist {
+		return NewNoticeIndex(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	x := NewNoticeIndex()
+	if err := x.UnmarshalBinary(b); err != nil {
+		return nil, fmt.Errorf("reading notice index %s: %v", key, err)
+	}
+	return x, nil
+}
+
+// Whether the notice of the fault has been archived
+func (x *NoticeIndex) Contains(faultId, noticeId int) bool {
+	return x.faults[faultId][noticeId]
+}
+
+// Record that the notice of the fault has been archived
+func (x *NoticeIndex) Add(faultId, noticeId int) {
+	if x.Contains(faultId, noticeId) {
+		return
+	}
+	notices, ok := x.faults[faultId]
+	if !ok {
+		notices = map[int]bool{}
+		x.faults[faultId] = notices
+	}
+	notices[noticeId] = true
+	x.added[faultId] = append(x.added[faultId], noticeId)
+}
+
+// Add every notice in another index, e.g. one checkpointed by an interrupted run
+func (x *NoticeIndex) Merge(other *NoticeIndex) {
+	for faultId, notices := range other.faults {
+		for noticeId := range notices {
+			x.Add(faultId, noticeId)
+		}
+	}
+}
+
+// The notices added since the index was read, as an index of their own
+func (x *NoticeIndex) Added() *NoticeIndex {
+	added := NewNoticeIndex()
+	for faultId, notices := range x.added {
+		for _, noticeId := range notices {
+			added.Add(faultId, noticeId)
+		}
+	}
+	return added
+}
+
+// The number of notices in the index
+func (x *NoticeIndex) Len() int {
+	n := 0
+	for _, notices := range x.faults {
+		n += len(notices)
+	}
+	return n
+}
+
+// Write the index to key
+func (x *NoticeIndex) Save(store Store, key string) error {
+	body, err := x.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return store.Put(key, body)
+}
+
+func (x *NoticeIndex) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(NOTICE_INDEX_MAGIC)
+	b.WriteByte(NOTICE_INDEX_VERSION)
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(v int) {
+		b.Write(buf[:binary.PutUvarint(buf, uint64(v))])
+	}
+	faultIds := make([]int, 0, len(x.faults))
+	for faultId := range x.faults {
+		faultIds = append(faultIds, faultId)
+	}
+	sort.Ints(faultIds)
+	put(len(faultIds))
+	prevFault := 0
+	for _, faultId := range faultIds {
+		put(faultId - prevFault)
+		prevFault = faultId
+		noticeIds := sortedIds(x.faults[faultId])
+		put(len(noticeIds))
+		prevNotice := 0
+		for _, noticeId := range noticeIds {
+			put(noticeId - prevNotice)
+			prevNotice = noticeId
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// Replaces the notices in the index with those encoded in data
+func (x *NoticeIndex) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(NOTICE_INDEX_MAGIC)) {
+		return errors.New("not a notice index")
+	}
+	data = data[len(NOTICE_INDEX_MAGIC):]
+	if len(data) < 1 {
+		return errNoticeIndexTruncated
+	}
+	if version := data[0]; version > NOTICE_INDEX_VERSION {
+		return fmt.Errorf("notice index is version %d, this version of honeybadger-s3 only reads up to version %d", version, NOTICE_INDEX_VERSION)
+	}
+	data = data[1:]
+	next := func() (int, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errNoticeIndexTruncated
+		}
+		data = data[n:]
+		return int(v), nil
+	}
+	faults := map[int]map[int]bool{}
+	faultCount, err := next()
+	if err != nil {
+		return err
+	}
+	faultId := 0
+	for i := 0; i < faultCount; i++ {
+		delta, err := next()
+		if err != nil {
+			return err
+		}
+		faultId += delta
+		noticeCount, err := next()
+		if err != nil {
+			return err
+		}
+		if noticeCount > len(data) {
+			return errNoticeIndexTruncated
+		}
+		notices := make(map[int]bool, noticeCount)
+		noticeId := 0
+		for j := 0; j < noticeCount; j++ {
+			if delta, err = next(); err != nil {
+				return err
+			}
+			noticeId += delta
+			notices[noticeId] = true
+		}
+		faults[faultId] = notices
+	}
+	if len(data) > 0 {
+		return fmt.Errorf("notice index has %d bytes after the last fault", len(data))
+	}
+	x.faults, x.added = faults, map[int][]int{}
+	return nil
+}
+
+func sortedIds(ids map[int]bool) []int {
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+// DailyNoticeIndex is a project's notice index split into a NoticeIndex per
+// day the notices were created, at <prefix>/<yyyy-mm-dd>.idx. A day is only
+// read once a notice from it is looked up, so a run holds just the days it
+// fetches notices from, and only the days it archived notices of are written
+type DailyNoticeIndex struct {
+	Store        Store
+	Prefix       string
+	days         map[string]*NoticeIndex
+	checkpointed map[string]int // How many notices each day had added when it was last checkpointed
+}
+
+func NewDailyNoticeIndex(store Store, prefix string) *DailyNoticeIndex {
+	return &DailyNoticeIndex{Store: store, Prefix: prefix, days: map[string]*NoticeIndex{}, checkpointed: map[string]int{}}
+}
+
+// The day a notice created at t is indexed under
+func noticeIndexDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func noticeIndexDayKey(prefix, day string) string {
+	return prefix + "/" + day + ".idx"
+}
+
+// The index of the day, read the first time it's needed
+func (x *DailyNoticeIndex) day(day string) (*NoticeIndex, error) {
+	if index, ok := x.days[day]; ok {
+		return index, nil
+	}
+	index, err := ReadNoticeIndex(x.Store, noticeIndexDayKey(x.Prefix, day))
+	if err != nil {
+		return nil, err
+	}
+	x.days[day] = index
+	return index, nil
+}
+
+// Whether the notice of the fault, created at t, has been archived
+func (x *DailyNoticeIndex) Contains(faultId, noticeId int, t time.Time) (bool, error) {
+	index, err := x.day(noticeIndexDay(t))
+	if err != nil {
+		return false, err
+	}
+	return index.Contains(faultId, noticeId), nil
+}
+
+// Record that the notice of the fault, created at t, has been archived
+func (x *DailyNoticeIndex) Add(faultId, noticeId int, t time.Time) error {
+	index, err := x.day(noticeIndexDay(t))
+	if err != nil {
+		return err
+	}
+	index.Add(faultId, noticeId)
+	return nil
+}
+
+// Add the notices of every day saved under prefix, e.g. those checkpointed by
+// an interrupted run
+func (x *DailyNoticeIndex) Merge(prefix string) error {
+	objects, err := x.Store.List(prefix + "/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		day := strings.TrimSuffix(path.Base(object.Key), ".idx")
+		if object.Key != noticeIndexDayKey(prefix, day) {
+			continue
+		}
+		other, err := ReadNoticeIndex(x.Store, object.Key)
+		if err != nil {
+			return err
+		}
+		index, err := x.day(day)
+		if err != nil {
+			return err
+		}
+		index.Merge(other)
+	}
+	return nil
+}
+
+// The number of notices added since the days were read
+func (x *DailyNoticeIndex) Added() int {
+	n := 0
+	for _, index := range x.days {
+		for _, notices := range index.added {
+			n += len(notices)
+		}
+	}
+	return n
+}
+
+// Write the days notices were added to
+func (x *DailyNoticeIndex) Save() error {
+	for day, index := range x.days {
+		if len(index.added) < 1 {
+			continue
+		}
+		if err := index.Save(x.Store, noticeIndexDayKey(x.Prefix, day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the notices added to each day under prefix, skipping the days that
+// haven't changed since they were last checkpointed
+func (x *DailyNoticeIndex) SaveAdded(prefix string) error {
+	for day, index := range x.days {
+		added := index.Added()
+		if added.Len() == x.checkpointed[day] {
+			continue
+		}
+		if err := added.Save(x.Store, noticeIndexDayKey(prefix, day)); err != nil {
+			return err
+		}
+		x.checkpointed[day] = added.Len()
+	}
+	return nil
+}
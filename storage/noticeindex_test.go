@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNoticeIndexRoundTrip(t *testing.T) {
+	x := NewNoticeIndex()
+	x.Add(42, 1000)
+	x.Add(42, 1003)
+	x.Add(42, 1001)
+	x.Add(7, 5)
+	x.Add(42, 1003)
+	b, err := x.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The magic and version, 2 faults, fault 7 with notice 5, then fault 42 as
+	// 35 after 7 with notice 1000 in 2 bytes, and 1001 and 1003 as 1 and 2
+	if len(b) != 5+1+3+1+1+2+1+1 {
+		t.Errorf("expected the index to be delta encoded but got %d bytes %v", len(b), b)
+	}
+
+	read := NewNoticeIndex()
+	if err := read.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if read.Len() != 4 {
+		t.Errorf("expected 4 notices but got %d", read.Len())
+	}
+	for _, id := range [][2]int{{42, 1000}, {42, 1001}, {42, 1003}, {7, 5}} {
+		if !read.Contains(id[0], id[1]) {
+			t.Errorf("expected notice %d of fault %d in the index", id[1], id[0])
+		}
+	}
+	if read.Contains(42, 1002) || read.Contains(8, 5) {
+		t.Error("expected notices that weren't added not to be in the index")
+	}
+
+	// Only notices added since reading are checkpointed
+	read.Add(42, 1002)
+	read.Add(42, 1000)
+	if added := read.Added(); added.Len() != 1 || !added.Contains(42, 1002) {
+		t.Errorf("expected just notice 1002 to have been added but got %d notices", added.Len())
+	}
+
+	if err := read.UnmarshalBinary(b[:len(b)-1]); err == nil {
+		t.Error("expected a truncated index to fail")
+	}
+}
+
+func TestDailyNoticeIndexOnlyReadsTheDaysLookedUp(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{}}
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	x := NewDailyNoticeIndex(store, "index/1")
+	x.Add(42, 1000, first)
+	x.Add(42, 2000, second)
+	if err := x.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.objects["index/1/2024-01-02.idx"]; !ok || len(store.objects) != 2 {
+		t.Errorf("expected a file per day but got %v", store.objects)
+	}
+
+	read := NewDailyNoticeIndex(store, "index/1")
+	if ok, err := read.Contains(42, 2000, second); err != nil || !ok {
+		t.Errorf("expected notice 2000 in the index but got %v %v", ok, err)
+	}
+	if len(read.days) != 1 {
+		t.Errorf("expected only the day looked up to be read but got %d days", len(read.days))
+	}
+
+	// Only the days with notices added since the last checkpoint are written
+	read.Add(42, 2001, second)
+	if err := read.SaveAdded("checkpoints/1"); err != nil {
+		t.Fatal(err)
+	}
+	delete(store.objects, "checkpoints/1/2024-01-02.idx")
+	read.Add(42, 1001, first)
+	if err := read.SaveAdded("checkpoints/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.objects["checkpoints/1/2024-01-02.idx"]; ok {
+		t.Error("expected the unchanged day not to be checkpointed again")
+	}
+
+	resumed := NewDailyNoticeIndex(store, "index/1")
+	if err := resumed.Merge("checkpoints/1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := resumed.Contains(42, 1001, first); !ok {
+		t.Error("expected the checkpointed notice 1001 in the index")
+	}
+	if resumed.Added() != 1 {
+		t.Errorf("expected the checkpointed notice to be added but got %d", resumed.Added())
+	}
+}
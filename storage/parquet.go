@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Rows per row group when no row group size is given
+const DEFAULT_ROW_GROUP_SIZE = 100000
+
+// A ParquetRecord can be written as a Parquet row with a stable schema
+type ParquetRecord interface {
+	ParquetRow() interface{}
+}
+
+// ParquetWriter writes records as the rows of a Parquet file. The schema comes
+// from the row of the first record, so every record written must have the same
+// row type. A row group is written out every RowGroupSize rows
+type ParquetWriter struct {
+	w            io.Writer
+	codec        parquet.CompressionCodec
+	rowGroupSize int
+	writer       *writer.ParquetWriter
+	rows         int
+}
+
+func NewParquetWriter(w io.Writer, compression Compression, rowGroupSize int) *ParquetWriter {
+	if rowGroupSize < 1 {
+		rowGroupSize = DEFAULT_ROW_GROUP_SIZE
+	}
+	return &ParquetWriter{w: w, codec: parquetCodec(compression), rowGroupSize: rowGroupSize}
+}
+
+// Write a single record
+func (p *ParquetWriter) Write(record interface{}) error {
+	r, ok := record.(ParquetRecord)
+	if !ok {
+		return fmt.Errorf("%T records can't be written as parquet", record)
+	}
+	row := r.ParquetRow()
+	if p.writer == nil {
+		pw, err := writer.NewParquetWriterFromWriter(p.w, row, 1)
+		if err != nil {
+			return err
+		}
+		pw.CompressionType = p.codec
+		p.writer = pw
+	}
+	if err := p.writer.Write(row); err != nil {
+		return err
+	}
+	p.rows++
+	if p.rows%p.rowGroupSize == 0 {
+		return p.writer.Flush(true)
+	}
+	return nil
+}
+
+// Write the last row group and the file footer. It doesn't close w
+func (p *ParquetWriter) Close() error {
+	if p.writer == nil {
+		// Nothing was written, so there's no schema to write a footer for
+		return nil
+	}
+	return p.writer.WriteStop()
+}
+
+// Parquet compresses its columns itself rather than the whole object
+func parquetCodec(compression Compression) parquet.CompressionCodec {
+	switch compression {
+	case CompressionGzip:
+		return parquet.CompressionCodec_GZIP
+	case CompressionZstd:
+		return parquet.CompressionCodec_ZSTD
+	}
+	return parquet.CompressionCodec_UNCOMPRESSED
+}
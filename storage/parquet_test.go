@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	hb "github.com/MasteryConnect/honeybadger-s3/honeybadger"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	var notices []*hb.Notice
+	for _, raw := range []string{
+		`{"id": 1, "fault_id": 4, "created_at": "2024-01-01T12:00:00Z", "request": {"params": {"q": "x"}},
+			"backtrace": [{"number": "42", "file": "app/models/user.rb", "method": "save"}, {"number": "7", "file": "app/controllers/users_controller.rb", "method": "create"}]}`,
+		`{"id": 2, "fault_id": 4, "created_at": "2024-01-01T12:05:00Z"}`,
+	} {
+		notice := &hb.Notice{}
+		if err := json.Unmarshal([]byte(raw), notice); err != nil {
+			t.Fatal(err)
+		}
+		notices = append(notices, notice)
+	}
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		var buf bytes.Buffer
+		e, err := NewEncoder(&buf, Output{Format: FormatParquet, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, notice := range notices {
+			if err := e.Write(notice); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(buf.Bytes()), new(hb.NoticeRow), 1)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		rows := make([]hb.NoticeRow, pr.GetNumRows())
+		if err := pr.Read(&rows); err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		pr.ReadStop()
+		if len(rows) != len(notices) {
+			t.Fatalf("%s: expected %d rows but got %d", compression, len(notices), len(rows))
+		}
+		for i, notice := range notices {
+			if expected := notice.ParquetRow().(*hb.NoticeRow); !reflect.DeepEqual(rows[i], *expected) {
+				t.Errorf("%s: expected row %d to read back as\n%+v\nbut got\n%+v", compression, i, *expected, rows[i])
+			}
+		}
+	}
+}
+
+// The LIST columns are written as the three level lists other readers expect
+func TestParquetListColumns(t *testing.T) {
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, Output{Format: FormatParquet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fault := &hb.Fault{}
+	if err := json.Unmarshal([]byte(`{"id": 4, "tags": ["slow", "billing"]}`), fault); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Write(fault); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(buf.Bytes()), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	paths := map[string]bool{}
+	for _, path := range pr.SchemaHandler.ValueColumns {
+		paths[path] = true
+	}
+	for _, path := range []string{
+		"Parquet_go_root\x01Tags\x01List\x01Element",
+		"Parquet_go_root\x01Tickets\x01List\x01Element",
+	} {
+		if !paths[path] {
+			t.Errorf("expected the column %q but got %v", path, pr.SchemaHandler.ValueColumns)
+		}
+	}
+
+	rows := make([]hb.FaultRow, 1)
+	rowReader, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(buf.Bytes()), new(hb.FaultRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rowReader.ReadStop()
+	if err := rowReader.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows[0].Tags, []string{"slow", "billing"}) {
+		t.Errorf("expected the tags back but got %v", rows[0].Tags)
+	}
+}
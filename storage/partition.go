@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Returns the key of the object a record from time t belongs in. A partition
+// is written to object 0, and to objects 1, 2 and so on if it's started again
+// after being closed
+type PartitionKey func(t time.Time, object int) string
+
+// How many partitions are open at once, unless configured
+const DEFAULT_MAX_OPEN_PARTITIONS = 16
+
+// PartitionedUpload streams records into one object per partition, where the
+// partition comes from each record's timestamp. Records without a timestamp go
+// in the partition of the Fallback time, e.g. when the run started.
+//
+// Each open partition holds an upload's buffers, so at most MaxOpen are open
+// at once. Opening another completes the one used longest ago, and if records
+// for that partition come along later they're written to a new object. OnClose
+// is told of each object completed that way, so it can be recorded before the
+// rest of the upload is done
+type PartitionedUpload struct {
+	Store    Store
+	Key      PartitionKey
+	Fallback time.Time
+	MaxOpen  int
+	OnClose  func(completed []Completed) error
+	uploads  map[string]Upload // The open partitions, by the key of their first object
+	keys     []string          // Open partitions in the order they were opened
+	used     map[string]int64  // When each open partition was last written to
+	uses     int64
+	objects  map[string]int // How many objects each partition has been written to
+	closed   []Completed    // The objects of partitions completed to make room for others
+}
+
+func NewPartitionedUpload(store Store, key PartitionKey, fallback time.Time) *PartitionedUpload {
+	return &PartitionedUpload{
+		Store:    store,
+		Key:      key,
+		Fallback: fallback,
+		MaxOpen:  DEFAULT_MAX_OPEN_PARTITIONS,
+		uploads:  map[string]Upload{},
+		used:     map[string]int64{},
+		objects:  map[string]int{},
+	}
+}
+
+// Carry on a partitioned upload from its last checkpoint, resuming the upload
+// of each partition it had open
+func ResumePartitionedUpload(store Store, key PartitionKey, fallback time.Time, state *UploadState) (*PartitionedUpload, error) {
+	p := NewPartitionedUpload(store, key, fallback)
+	p.closed = append(p.closed, state.Closed...)
+	for partition, objects := range state.Objects {
+		p.objects[partition] = objects
+	}
+	for _, open := range state.Partitions {
+		upload, err := store.ResumeUpload(open)
+		if err != nil {
+			p.AbortUpload()
+			return nil, err
+		}
+		// Checkpoints from before partitions could be closed only name the object
+		partition := open.Partition
+		if len(partition) < 1 {
+			partition = open.Key
+		}
+		p.open(partition, upload)
+		if p.objects[partition] < 1 {
+			p.objects[partition] = 1
+		}
+	}
+	return p, nil
+}
+
+// Partitions are created as records arrive for them, so there's nothing to do
+func (p *PartitionedUpload) CreateUpload() error {
+	return nil
+}
+
+// Save a honeybadger record to the upload of its partition
+func (p *PartitionedUpload) Upload(hbRecord interface{}) error {
+	t := p.Fallback
+	if r, ok := hbRecord.(Timestamped); ok && !r.Timestamp().IsZero() {
+		t = r.Timestamp()
+	}
+	partition := p.Key(t, 0)
+	upload, ok := p.uploads[partition]
+	if !ok {
+		if p.MaxOpen > 0 && len(p.keys) >= p.MaxOpen {
+			if err := p.closeLeastRecentlyUsed(); err != nil {
+				return err
+			}
+		}
+		upload = p.Store.NewUpload(p.Key(t, p.objects[partition]))
+		if err := upload.CreateUpload(); err != nil {
+			return err
+		}
+		p.open(partition, upload)
+		p.objects[partition]++
+	}
+	p.uses++
+	p.used[partition] = p.uses
+	return upload.Upload(hbRecord)
+}
+
+func (p *PartitionedUpload) open(partition string, upload Upload) {
+	p.uploads[partition] = upload
+	p.keys = append(p.keys, partition)
+	p.uses++
+	p.used[partition] = p.uses
+}
+
+// Complete the open partition written to longest ago
+func (p *PartitionedUpload) closeLeastRecentlyUsed() error {
+	oldest := 0
+	for i, partition := range p.keys {
+		if p.used[partition] < p.used[p.keys[oldest]] {
+			oldest = i
+		}
+	}
+	partition := p.keys[oldest]
+	upload := p.uploads[partition]
+	p.keys = append(p.keys[:oldest], p.keys[oldest+1:]...)
+	delete(p.uploads, partition)
+	delete(p.used, partition)
+	completed, err := upload.CompleteUpload()
+	if err != nil {
+		upload.AbortUpload()
+		return err
+	}
+	log.WithFields(log.Fields{"partition": upload.FileLocation(), "open": len(p.keys)}).Debug("Closed partition")
+	p.closed = append(p.closed, completed...)
+	if p.OnClose != nil {
+		return p.OnClose(completed)
+	}
+	return nil
+}
+
+// Checkpoint the upload of every open partition, along with the objects of
+// those already closed
+func (p *PartitionedUpload) Checkpoint() (*UploadState, error) {
+	state := &UploadState{Partitioned: true, Partitions: []*UploadState{}, Closed: p.closed, Objects: map[string]int{}}
+	for partition, objects := range p.objects {
+		state.Objects[partition] = objects
+	}
+	for _, partition := range p.keys {
+		open, err := p.uploads[partition].Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+		open.Partition = partition
+		state.Partitions = append(state.Partitions, open)
+	}
+	return state, nil
+}
+
+// Complete the upload of every open partition. The objects of the partitions
+// already closed are returned too. If a partition fails, the rest are
+// aborted and the objects completed are deleted, so the upload either
+// completes as a whole or leaves nothing behind
+func (p *PartitionedUpload) CompleteUpload() ([]Completed, error) {
+	for i, partition := range p.keys {
+		completed, err := p.uploads[partition].CompleteUpload()
+		if err != nil {
+			p.keys = p.keys[i+1:]
+			p.AbortUpload()
+			return nil, err
+		}
+		p.closed = append(p.closed, completed...)
+	}
+	p.keys = nil
+	return p.closed, nil
+}
+
+// Abort the upload of every open partition, and delete the objects of those
+// already closed, so nothing of the upload is left in the store
+func (p *PartitionedUpload) AbortUpload() {
+	for _, partition := range p.keys {
+		p.uploads[partition].AbortUpload()
+	}
+	for _, completed := range p.closed {
+		if err := p.Store.Delete(completed.Key); err != nil {
+			log.WithFields(log.Fields{"object": completed.Location}).Warn(err)
+		}
+	}
+	p.keys, p.closed = nil, nil
+}
+
+func (p *PartitionedUpload) HandleError(err error) {
+	log.WithFields(log.Fields{
+		"partitions": p.FileLocation(),
+	}).Error(err)
+	p.AbortUpload()
+}
+
+func (p *PartitionedUpload) FileLocation() string {
+	var locations []string
+	for _, partition := range p.keys {
+		locations = append(locations, p.uploads[partition].FileLocation())
+	}
+	return strings.Join(locations, ", ")
+}
+
+// RawRecord is a record archived as the exact JSON the API returned for it,
+// along with the record's timestamp for partitioning
+type RawRecord struct {
+	JSON json.RawMessage
+	Time time.Time
+}
+
+func (r RawRecord) MarshalJSON() ([]byte, error) {
+	return r.JSON, nil
+}
+
+func (r RawRecord) Timestamp() time.Time {
+	return r.Time
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A store that keeps what's uploaded to each key in memory
+type memoryStore struct {
+	Store
+	records map[string][]interface{}
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Read(key string) (io.ReadCloser, error) {
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (s *memoryStore) Put(key string, body []byte) error {
+	s.objects[key] = body
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	delete(s.records, key)
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memoryStore) List(prefix string) ([]Object, error) {
+	var objects []Object
+	for key, body := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: int64(len(body))})
+		}
+	}
+	return objects, nil
+}
+
+func (s *memoryStore) NewUpload(key string) Upload {
+	return &memoryUpload{store: s, key: key}
+}
+
+type memoryUpload struct {
+	store *memoryStore
+	key   string
+}
+
+func (u *memoryUpload) CreateUpload() error { return nil }
+func (u *memoryUpload) AbortUpload()        {}
+func (u *memoryUpload) HandleError(error)   {}
+func (u *memoryUpload) FileLocation() string {
+	return u.key
+}
+func (u *memoryUpload) Upload(hbRecord interface{}) error {
+	u.store.records[u.key] = append(u.store.records[u.key], hbRecord)
+	return nil
+}
+func (u *memoryUpload) Checkpoint() (*UploadState, error) {
+	return &UploadState{Key: u.key}, nil
+}
+func (u *memoryUpload) CompleteUpload() ([]Completed, error) {
+	return []Completed{{Location: u.key, Records: int64(len(u.store.records[u.key]))}}, nil
+}
+
+func TestPartitionedUploadByRecordTime(t *testing.T) {
+	store := &memoryStore{records: map[string][]interface{}{}}
+	fallback := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	upload := NewPartitionedUpload(store, func(t time.Time, object int) string {
+		return t.Format("dt=2006-01-02")
+	}, fallback)
+
+	records := []interface{}{
+		RawRecord{JSON: json.RawMessage(`{"id":1}`), Time: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		RawRecord{JSON: json.RawMessage(`{"id":2}`), Time: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+		RawRecord{JSON: json.RawMessage(`{"id":3}`), Time: time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)},
+		RawRecord{JSON: json.RawMessage(`{"id":4}`)},
+	}
+	for _, record := range records {
+		if err := upload.Upload(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locations, err := upload.CompleteUpload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Completed{
+		{Location: "dt=2024-01-01", Records: 2},
+		{Location: "dt=2024-01-02", Records: 1},
+		{Location: "dt=2024-01-03", Records: 1},
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("expected %v but got %v", expected, locations)
+	}
+}
+
+func TestPartitionedUploadClosesLeastRecentlyUsed(t *testing.T) {
+	store := &memoryStore{records: map[string][]interface{}{}}
+	upload := NewPartitionedUpload(store, func(t time.Time, object int) string {
+		return t.Format("dt=2006-01-02") + "/" + strconv.Itoa(object)
+	}, time.Time{})
+	upload.MaxOpen = 2
+	var closed []string
+	upload.OnClose = func(completed []Completed) error {
+		for _, c := range completed {
+			closed = append(closed, c.Location)
+		}
+		return nil
+	}
+
+	day := func(d int) RawRecord {
+		return RawRecord{JSON: json.RawMessage(`{}`), Time: time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)}
+	}
+	// Day 2 is used longest ago when day 3 arrives, so it's closed, and day 2
+	// coming back goes to a new object
+	for _, d := range []int{1, 2, 1, 3, 2} {
+		if err := upload.Upload(day(d)); err != nil {
+			t.Fatal(err)
+		}
+		if len(upload.keys) > upload.MaxOpen {
+			t.Fatalf("expected at most %d partitions open but got %d", upload.MaxOpen, len(upload.keys))
+		}
+	}
+	if expected := []string{"dt=2024-01-02/0", "dt=2024-01-01/0"}; !reflect.DeepEqual(closed, expected) {
+		t.Errorf("expected to be told of %v as they closed but got %v", expected, closed)
+	}
+	state, err := upload.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Closed) != 2 || len(state.Partitions) != 2 {
+		t.Errorf("expected 2 closed and 2 open partitions checkpointed but got %d and %d", len(state.Closed), len(state.Partitions))
+	}
+	locations, err := upload.CompleteUpload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, l := range locations {
+		keys = append(keys, l.Location)
+	}
+	sort.Strings(keys)
+	expected := []string{"dt=2024-01-01/0", "dt=2024-01-02/0", "dt=2024-01-02/1", "dt=2024-01-03/0"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v but got %v", expected, keys)
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format is how records are framed in an object
+type Format string
+
+const (
+	FormatNDJSON    Format = "ndjson" // One JSON record per line
+	FormatJSONArray Format = "json"   // A single JSON array of records
+	FormatParquet   Format = "parquet"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "", FormatNDJSON:
+		return FormatNDJSON, nil
+	case FormatJSONArray, FormatParquet:
+		return Format(format), nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected ndjson, json or parquet", format)
+}
+
+// RecordWriter writes JSON records to w, framed according to its format
+type RecordWriter struct {
+	w      io.Writer
+	format Format
+	count  int
+}
+
+func NewRecordWriter(w io.Writer, format Format) *RecordWriter {
+	return &RecordWriter{w: w, format: format}
+}
+
+// Write a single record
+func (r *RecordWriter) Write(record interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var frame []byte
+	switch {
+	case r.format == FormatJSONArray && r.count == 0:
+		frame = append([]byte("[\n"), b...)
+	case r.format == FormatJSONArray:
+		frame = append([]byte(",\n"), b...)
+	default:
+		// json.Marshal never outputs a raw newline, so every record is one line
+		frame = append(b, '\n')
+	}
+	r.count++
+	_, err = r.w.Write(frame)
+	return err
+}
+
+// Write anything needed to end the records, e.g. the closing bracket of a
+// JSON array. It doesn't close w
+func (r *RecordWriter) Close() error {
+	if r.format != FormatJSONArray {
+		return nil
+	}
+	end := "\n]\n"
+	if r.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(r.w, end)
+	return err
+}
+
+// RecordReader reads the records from an object in any of the formats: NDJSON,
+// a JSON array, or JSON records written back to back as older versions did.
+// Compressed objects are decompressed
+type RecordReader struct {
+	body    io.ReadCloser
+	plain   io.ReadCloser // The decompressed body
+	buf     *bufio.Reader
+	dec     *json.Decoder
+	array   bool
+	started bool
+}
+
+func NewRecordReader(body io.ReadCloser) (*RecordReader, error) {
+	plain, err := decompress(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	buf := bufio.NewReader(plain)
+	return &RecordReader{body: body, plain: plain, buf: buf, dec: json.NewDecoder(buf)}, err
+}
+
+// Returns the next record, or io.EOF when there are no more records
+func (r *RecordReader) Next() (json.RawMessage, error) {
+	if !r.started {
+		r.started = true
+		if err := r.start(); err != nil {
+			return nil, err
+		}
+	}
+	if r.array && !r.dec.More() {
+		// Consume the closing bracket
+		if _, err := r.dec.Token(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	var record json.RawMessage
+	if err := r.dec.Decode(&record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (r *RecordReader) Close() error {
+	r.plain.Close()
+	return r.body.Close()
+}
+
+// Works out whether the records are in a JSON array from the first character
+func (r *RecordReader) start() error {
+	for {
+		c, err := r.buf.Peek(1)
+		if err == io.EOF {
+			return io.EOF
+		} else if err != nil {
+			return err
+		}
+		if !bytes.ContainsAny(c, " \t\r\n") {
+			r.array = c[0] == '['
+			break
+		}
+		r.buf.ReadByte()
+	}
+	if r.array {
+		// Consume the opening bracket
+		_, err := r.dec.Token()
+		return err
+	}
+	return nil
+}
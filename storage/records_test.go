@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, body string) []string {
+	r, err := NewRecordReader(ioutil.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatalf("reading %q: %v", body, err)
+		}
+		records = append(records, string(record))
+	}
+}
+
+func TestRecordsRoundTrip(t *testing.T) {
+	for _, format := range []Format{FormatNDJSON, FormatJSONArray} {
+		var buf bytes.Buffer
+		w := NewRecordWriter(&buf, format)
+		for _, id := range []int{1, 2} {
+			if err := w.Write(map[string]int{"id": id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if records := readAll(t, buf.String()); strings.Join(records, " ") != `{"id":1} {"id":2}` {
+			t.Errorf("%s: expected both records back but got %v from %q", format, records, buf.String())
+		}
+	}
+}
+
+func TestCompressedRecordsRoundTrip(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		var buf bytes.Buffer
+		e, err := NewEncoder(&buf, Output{Format: FormatNDJSON, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			e.Write(map[string]string{"backtrace": "app/models/fault.rb:42"})
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if records := readAll(t, buf.String()); len(records) != 100 {
+			t.Errorf("%s: expected 100 records but got %d", compression, len(records))
+		}
+	}
+}
+
+func TestNDJSONHasOneRecordPerLine(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRecordWriter(&buf, FormatNDJSON)
+	w.Write(map[string]string{"message": "line 1\nline 2"})
+	w.Write(map[string]string{"message": "line 3"})
+	if lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); len(lines) != 2 {
+		t.Errorf("expected 2 lines but got %q", buf.String())
+	}
+}
+
+func TestReadLegacyAndEmptyRecords(t *testing.T) {
+	if records := readAll(t, `{"id":1}{"id":2}{"id":3}`); len(records) != 3 {
+		t.Errorf("expected 3 back to back records but got %v", records)
+	}
+	var buf bytes.Buffer
+	NewRecordWriter(&buf, FormatJSONArray).Close()
+	if records := readAll(t, buf.String()); len(records) != 0 {
+		t.Errorf("expected no records in an empty array but got %v", records)
+	}
+	if records := readAll(t, ""); len(records) != 0 {
+		t.Errorf("expected no records in an empty object but got %v", records)
+	}
+}
+
+func TestClosingAnEncoderAgainDoesNothing(t *testing.T) {
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, Output{Format: FormatNDJSON, Compression: CompressionZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Write(map[string]int{"id": 1})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	closed := buf.Len()
+	if err := e.Close(); err != nil {
+		t.Errorf("expected closing the encoder again to succeed but got %v", err)
+	}
+	if buf.Len() != closed {
+		t.Errorf("expected nothing more written closing the encoder again but got %d bytes", buf.Len()-closed)
+	}
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The version of the run state document this writes. Bump it when the
+// document changes in a way older versions can't read
+const STATE_VERSION = 1
+
+// Statuses of the last run
+const (
+	RUN_RUNNING   = "running"
+	RUN_SUCCEEDED = "succeeded"
+	RUN_FAILED    = "failed"
+)
+
+// State is what a run leaves behind for the next one, saved as JSON
+type State struct {
+	Version       int                   `json:"version"`
+	LastRunId     string                `json:"last_run_id,omitempty"`
+	LastRunStatus string                `json:"last_run_status,omitempty"`
+	LastRunError  string                `json:"last_run_error,omitempty"`
+	Projects      map[int]*ProjectState `json:"projects"`                  // Keyed by project ID
+	Legacy        map[string]int64      `json:"legacy_projects,omitempty"` // Timestamps migrated from the legacy run data, keyed by lower case project name, until the project is next backed up
+}
+
+// ProjectState is where the next backup of a project starts from
+type ProjectState struct {
+	Name        string           `json:"name"`
+	Faults      int64            `json:"faults_watermark"`       // The latest notice of the faults backed up, as a unix time
+	Notices     int64            `json:"notices_watermark"`      // The latest notice backed up, as a unix time
+	FaultsSeen  map[string]int64 `json:"faults_seen,omitempty"`  // Faults backed up in the overlap before the watermark
+	NoticesSeen map[string]int64 `json:"notices_seen,omitempty"` // Notices backed up in the overlap before the watermark
+}
+
+type RunData struct {
+	Store             Store
+	Key               string // The JSON state document
+	LegacyKey         string // The legacy name:timestamp text file migrated from
+	Loaded            bool   // Stored run data was loaded
+	OverrideTimestamp int64  // Overrides all other timestamps
+	State             *State
+	Next              map[int]*ProjectState // Where the projects backed up this run start from next run
+}
+
+// Returns the run data at key. Every timestamp is overridden by lastRun when
+// it's given, which has to be a run ID e.g. 20240101120000
+func NewRunData(store Store, key, legacyKey, lastRun string) (*RunData, error) {
+	r := &RunData{Store: store, Key: key, LegacyKey: legacyKey, Next: map[int]*ProjectState{}}
+	if lastRun != "" {
+		timestamp, err := time.Parse(RUN_ID_FORMAT, lastRun)
+		if err != nil {
+			return nil, fmt.Errorf("last run %q isn't a run ID e.g. 20240101120000: %v", lastRun, err)
+		}
+		log.WithFields(log.Fields{
+			"last run string": lastRun,
+			"last run":        timestamp,
+		}).Debug("run data")
+		r.OverrideTimestamp = timestamp.Unix()
+	}
+	return r, nil
+}
+
+// Load the run state from the store, migrating the legacy run data when
+// there's no state document yet
+func (r *RunData) load() error {
+	r.Loaded = true
+	r.State = &State{Version: STATE_VERSION, Projects: map[int]*ProjectState{}}
+	state, err := ReadState(r.Store, r.Key)
+	if err == ErrNotExist {
+		return r.migrate()
+	} else if err != nil {
+		return err
+	}
+	r.State = state
+	return nil
+}
+
+// Read the run state document at key. Returns ErrNotExist if there isn't one
+func ReadState(store Store, key string) (*State, error) {
+	body, err := store.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("reading run state %s: %v", key, err)
+	}
+	if state.Version > STATE_VERSION {
+		return nil, fmt.Errorf("run state %s is version %d, this version of honeybadger-s3 only reads up to version %d", key, state.Version, STATE_VERSION)
+	}
+	if state.Projects == nil {
+		state.Projects = map[int]*ProjectState{}
+	}
+	return state, nil
+}
+
+// Read the timestamps of the legacy run data, if there is any. They're keyed
+// by project name so they're looked up by name until each project is next
+// backed up, when it's saved under its ID
+func (r *RunData) migrate() error {
+	if len(r.LegacyKey) < 1 {
+		return nil
+	}
+	body, err := r.Store.Read(r.LegacyKey)
+	if err == ErrNotExist {
+		// This is the first run, all timestamps default to 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer body.Close()
+	legacy, err := parseLegacyRunData(body)
+	if err != nil {
+		return fmt.Errorf("reading legacy run data %s: %v", r.LegacyKey, err)
+	}
+	log.WithFields(log.Fields{"from": r.LegacyKey, "to": r.Key, "projects": len(legacy)}).Info("Migrating run data")
+	r.State.Legacy = legacy
+	return nil
+}
+
+// Parses the legacy run data, a line per project of the form
+// project-name:timestamp
+func parseLegacyRunData(body io.Reader) (map[string]int64, error) {
+	legacy := map[string]int64{}
+	scanner := bufio.NewScanner(body)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) < 1 {
+			continue
+		}
+		// Project names may have colons in them, the timestamp can't
+		i := strings.LastIndex(text, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d %q has no timestamp", line, text)
+		}
+		timestamp, err := strconv.ParseInt(strings.TrimSpace(text[i+1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d %q: %v", line, text, err)
+		}
+		// Clean up project name with ToLower and Trim in case it was manually
+		// edited
+		legacy[strings.ToLower(strings.TrimSpace(text[:i]))] = timestamp
+	}
+	return legacy, scanner.Err()
+}
+
+// Get where the backup of a project starts from. This will read in the saved
+// run state from the store if an override timestamp has not been specified.
+// A project that's never been backed up starts from 0
+func (r *RunData) GetPrevTimestamps(projectId int, projectName string) (*ProjectState, error) {
+	prev := &ProjectState{Name: projectName}
+	if r.OverrideTimestamp != 0 {
+		// An override timestamp was passed in, so use that for all projects
+		prev.Faults, prev.Notices = r.OverrideTimestamp, r.OverrideTimestamp
+		return prev, nil
+	}
+	if !r.Loaded {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	if p, ok := r.State.Projects[projectId]; ok {
+		*prev = *p
+		prev.Name = projectName
+	} else if ts, ok := r.State.Legacy[strings.ToLower(projectName)]; ok {
+		prev.Faults, prev.Notices = ts, ts
+	}
+	return prev, nil
+}
+
+// Set where the next backup of a project starts from, once this run has
+// backed it up
+func (r *RunData) SetNext(projectId int, next ProjectState) {
+	r.Next[projectId] = &next
+	log.WithFields(log.Fields{
+		"project":      next.Name,
+		"next faults":  time.Unix(next.Faults, 0),
+		"next notices": time.Unix(next.Notices, 0),
+	}).Info("run data")
+}
+
+// Save the next timestamps of a project as soon as it's backed up, so a later
+// failure in the run doesn't lose them
+func (r *RunData) CommitProject(runId string, projectId int) error {
+	next, ok := r.Next[projectId]
+	if !ok {
+		return nil
+	}
+	if err := r.save(runId, RUN_RUNNING, nil, map[int]*ProjectState{projectId: next}); err != nil {
+		return err
+	}
+	delete(r.Next, projectId)
+	return nil
+}
+
+// Save the next timestamps of the projects backed up and not yet committed,
+// and how the run went, to the store for the next run to use. Projects that
+// weren't backed up this run keep their previous timestamps
+func (r *RunData) SaveNextRun(runId string, runErr error) error {
+	status := RUN_SUCCEEDED
+	if runErr != nil {
+		status = RUN_FAILED
+	}
+	if err := r.save(runId, status, runErr, r.Next); err != nil {
+		return err
+	}
+	r.Next = map[int]*ProjectState{}
+	return nil
+}
+
+func (r *RunData) save(runId, status string, runErr error, next map[int]*ProjectState) error {
+	if !r.Loaded {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+	state := r.State
+	state.Version = STATE_VERSION
+	state.LastRunId = runId
+	state.LastRunStatus, state.LastRunError = status, ""
+	if runErr != nil {
+		state.LastRunError = runErr.Error()
+	}
+	for id, n := range next {
+		state.Projects[id] = n
+		delete(state.Legacy, strings.ToLower(n.Name))
+	}
+	if len(state.Legacy) < 1 {
+		state.Legacy = nil
+	}
+	body, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return r.Store.Put(r.Key, append(body, '\n'))
+}
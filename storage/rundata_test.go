@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunDataMigratesLegacyRunData(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{
+		"run-data.txt": []byte("mindful:100\nBackend: API :200\n\n"),
+	}}
+	r, err := NewRunData(store, "state.json", "run-data.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := r.GetPrevTimestamps(1, "Mindful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Faults != 100 || prev.Notices != 100 {
+		t.Errorf("expected the legacy timestamp 100 but got %+v", prev)
+	}
+	r.SetNext(1, ProjectState{Name: "Mindful", Faults: 150, Notices: 160})
+	if err := r.SaveNextRun("20240101120000", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(store.objects["state.json"], state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != STATE_VERSION || state.LastRunId != "20240101120000" || state.LastRunStatus != RUN_SUCCEEDED {
+		t.Errorf("expected the run to be saved but got %+v", state)
+	}
+	if p := state.Projects[1]; p == nil || p.Faults != 150 || p.Notices != 160 {
+		t.Errorf("expected project 1 to move on to 150 and 160 but got %+v", p)
+	}
+	// The project that wasn't backed up keeps its legacy timestamp
+	if ts := state.Legacy["backend: api"]; ts != 200 || len(state.Legacy) != 1 {
+		t.Errorf("expected backend: api to keep 200 but got %v", state.Legacy)
+	}
+}
+
+func TestRunDataRejectsMalformedLegacyRunData(t *testing.T) {
+	for _, body := range []string{"mindful\n", "mindful:yesterday\n"} {
+		store := &memoryStore{objects: map[string][]byte{"run-data.txt": []byte(body)}}
+		r, err := NewRunData(store, "state.json", "run-data.txt", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.GetPrevTimestamps(1, "mindful"); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("expected an error for line 1 of %q but got %v", body, err)
+		}
+	}
+}
+
+func TestRunDataCommitsEachProject(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{}}
+	r, err := NewRunData(store, "state.json", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 2} {
+		if _, err := r.GetPrevTimestamps(id, "project"); err != nil {
+			t.Fatal(err)
+		}
+		r.SetNext(id, ProjectState{Name: "project", Faults: 100, Notices: 100})
+	}
+	if err := r.CommitProject("20240101120000", 1); err != nil {
+		t.Fatal(err)
+	}
+	state := &State{}
+	if err := json.Unmarshal(store.objects["state.json"], state); err != nil {
+		t.Fatal(err)
+	}
+	if state.LastRunStatus != RUN_RUNNING || state.Projects[1] == nil || state.Projects[2] != nil {
+		t.Errorf("expected only project 1 to be committed but got %+v", state)
+	}
+
+	// Project 2 fails after its notices, the run saves where they got to
+	if err := r.SaveNextRun("20240101120000", errors.New("project 2 failed")); err != nil {
+		t.Fatal(err)
+	}
+	state = &State{}
+	if err := json.Unmarshal(store.objects["state.json"], state); err != nil {
+		t.Fatal(err)
+	}
+	if state.LastRunStatus != RUN_FAILED || state.Projects[1] == nil || state.Projects[2] == nil {
+		t.Errorf("expected a failed run with both projects but got %+v", state)
+	}
+}
+
+func TestRunDataRejectsMalformedLastRun(t *testing.T) {
+	if _, err := NewRunData(&memoryStore{}, "state.json", "", "yesterday"); err == nil || !strings.Contains(err.Error(), "yesterday") {
+		t.Errorf("expected an error naming the last run but got %v", err)
+	}
+	r, err := NewRunData(&memoryStore{}, "state.json", "", "20240101120000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OverrideTimestamp != 1704110400 {
+		t.Errorf("expected 1704110400 but got %d", r.OverrideTimestamp)
+	}
+}
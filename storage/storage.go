@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Returned by Store.Read when the key doesn't exist in the store
+var ErrNotExist = errors.New("object does not exist")
+
+// Returned by a conditional write when the object isn't in the state required
+var ErrPreconditionFailed = errors.New("object was changed by someone else")
+
+// A Store is somewhere backups can be written to and read back from, e.g. an
+// S3 bucket or a directory on the local filesystem
+type Store interface {
+	// Create a new upload that streams records to the object at key
+	NewUpload(key string) Upload
+	// Carry on an upload from its last checkpoint, e.g. after a restart
+	ResumeUpload(state *UploadState) (Upload, error)
+	// Read the object at key. Returns ErrNotExist if there is no such object
+	Read(key string) (io.ReadCloser, error)
+	// Write body as the whole object at key, replacing any existing object
+	Put(key string, body []byte) error
+	// Write the object at key from body as it's read, without holding it all
+	// in memory, replacing any existing object. Once body has been read,
+	// verify is called if it isn't nil, and nothing is written unless it
+	// returns nil
+	PutStream(key string, body io.Reader, verify func() error) error
+	// Read the whole object at key along with its version, e.g. its ETag.
+	// Returns ErrNotExist if there is no such object
+	ReadVersion(key string) ([]byte, string, error)
+	// Write body as the object at key only if there is no object at key yet,
+	// returning the new version. Returns ErrPreconditionFailed if there is
+	PutIfNoneMatch(key string, body []byte) (string, error)
+	// Replace the object at key only if it's still at version, returning the
+	// new version. Returns ErrPreconditionFailed if it's changed
+	PutIfMatch(key string, body []byte, version string) (string, error)
+	// Delete the object at key. Deleting an object that doesn't exist isn't an
+	// error
+	Delete(key string) error
+	// List the objects whose keys start with prefix
+	List(prefix string) ([]Object, error)
+	// Clean up any uploads under prefix that were never completed, except
+	// those to the keys in keep, which a later run will carry on
+	CleanUpFailedUploads(prefix string, keep map[string]bool)
+}
+
+// An Upload streams honeybadger records to a single object. Nothing is visible
+// in the store until the upload is completed
+type Upload interface {
+	// Create the object stream
+	CreateUpload() error
+	// Write a honeybadger record to the object stream
+	Upload(hbRecord interface{}) error
+	// Make everything written so far durable and return the state to carry
+	// on from with Store.ResumeUpload. Returns ErrNotResumable if the upload
+	// can't be carried on
+	Checkpoint() (*UploadState, error)
+	// Complete the object stream, returning the objects written. Nothing is
+	// written when no records were uploaded
+	CompleteUpload() ([]Completed, error)
+	// Abort the object stream, discarding anything written to it
+	AbortUpload()
+	// Log err and abort the object stream
+	HandleError(err error)
+	// The location of the object e.g. bucket/key
+	FileLocation() string
+}
+
+// A Timestamped record knows when it happened, which partitions it by time
+type Timestamped interface {
+	Timestamp() time.Time
+}
+
+// An Object is an entry returned by Store.List
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Completed is an object written by an upload, and what was written to it
+type Completed struct {
+	Key      string    `json:"key"`
+	Location string    `json:"location"` // e.g. bucket/key
+	Records  int64     `json:"records"`
+	Bytes    int64     `json:"bytes"`          // The size of the object as stored
+	SHA256   string    `json:"sha256"`         // Hex encoded digest of the object as stored
+	ETag     string    `json:"etag,omitempty"` // The ETag S3 gave the object
+	MinTime  time.Time `json:"min_time"`       // The earliest record timestamp
+	MaxTime  time.Time `json:"max_time"`       // The latest record timestamp
+}
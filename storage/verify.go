@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// What verifying an object can find wrong with it
+const (
+	PROBLEM_MISSING   = "missing"
+	PROBLEM_TRUNCATED = "truncated"
+	PROBLEM_CORRUPT   = "corrupt"
+)
+
+// Rows read from a parquet file at a time while verifying it
+const VERIFY_PARQUET_BATCH = 1000
+
+// A Mismatch is an archived object that isn't what was written
+type Mismatch struct {
+	Key     string
+	Problem string
+	Detail  string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("%s is %s: %s", m.Key, m.Problem, m.Detail)
+}
+
+// Verifies the object of a manifest entry exists, has the size and digest it
+// was written with, and that every record in it can be read back. Returns the
+// mismatch if it doesn't, or an error if the object couldn't be read at all
+func VerifyEntry(store Store, entry ManifestEntry) (*Mismatch, error) {
+	r, mismatch, err := open(store, entry.Key)
+	if mismatch != nil || err != nil {
+		return mismatch, err
+	}
+	defer r.Close()
+	body := &digestReader{r: r, hash: sha256.New()}
+	count, unreadable, err := countRecords(entry.Key, body)
+	if err != nil {
+		return nil, err
+	}
+	if body.n < entry.Bytes {
+		return &Mismatch{entry.Key, PROBLEM_TRUNCATED, fmt.Sprintf("%d of %d bytes", body.n, entry.Bytes)}, nil
+	}
+	if body.n != entry.Bytes {
+		return &Mismatch{entry.Key, PROBLEM_CORRUPT, fmt.Sprintf("%d bytes, expected %d", body.n, entry.Bytes)}, nil
+	}
+	if digest := hex.EncodeToString(body.hash.Sum(nil)); digest != entry.SHA256 {
+		return &Mismatch{entry.Key, PROBLEM_CORRUPT, fmt.Sprintf("sha256 %s, expected %s", digest, entry.SHA256)}, nil
+	}
+	if unreadable != nil {
+		return unreadable, nil
+	}
+	if count < entry.Records {
+		return &Mismatch{entry.Key, PROBLEM_TRUNCATED, fmt.Sprintf("%d of %d records", count, entry.Records)}, nil
+	}
+	if count != entry.Records {
+		return &Mismatch{entry.Key, PROBLEM_CORRUPT, fmt.Sprintf("%d records, expected %d", count, entry.Records)}, nil
+	}
+	return nil, nil
+}
+
+// Verifies every record in the object at key can be read back, for objects
+// without a manifest. Returns the number of records
+func VerifyObject(store Store, key string) (int64, *Mismatch, error) {
+	r, mismatch, err := open(store, key)
+	if mismatch != nil || err != nil {
+		return 0, mismatch, err
+	}
+	defer r.Close()
+	return countRecords(key, r)
+}
+
+// Whether the key is of a run manifest rather than of archived records
+func IsManifest(key string) bool {
+	return strings.Contains("/"+key, "/manifests/")
+}
+
+func open(store Store, key string) (io.ReadCloser, *Mismatch, error) {
+	r, err := store.Read(key)
+	if err == ErrNotExist {
+		return nil, &Mismatch{key, PROBLEM_MISSING, err.Error()}, nil
+	}
+	return r, nil, err
+}
+
+// Reads every record in an object, in the format its key's extension says,
+// and then the rest of body so all of it has been read. Records are decoded
+// as they're read, only parquet is held in memory as its footer comes last
+func countRecords(key string, body io.Reader) (int64, *Mismatch, error) {
+	if strings.HasSuffix(key, ".parquet") {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		count, mismatch := countParquetRows(key, b)
+		return count, mismatch, nil
+	}
+	count, mismatch := countTextRecords(key, body)
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return 0, nil, err
+	}
+	return count, mismatch, nil
+}
+
+func countTextRecords(key string, body io.Reader) (int64, *Mismatch) {
+	records, err := NewRecordReader(ioutil.NopCloser(body))
+	if err != nil {
+		return 0, unreadable(key, 0, err)
+	}
+	defer records.Close()
+	var count int64
+	for {
+		_, err := records.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, unreadable(key, count, err)
+		}
+		count++
+	}
+}
+
+func countParquetRows(key string, body []byte) (count int64, mismatch *Mismatch) {
+	// The reader panics on some malformed files rather than returning an error
+	defer func() {
+		if r := recover(); r != nil {
+			mismatch = &Mismatch{key, PROBLEM_CORRUPT, fmt.Sprint(r)}
+		}
+	}()
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(body), nil, 1)
+	if err != nil {
+		return 0, &Mismatch{key, PROBLEM_CORRUPT, err.Error()}
+	}
+	defer pr.ReadStop()
+	rows := pr.GetNumRows()
+	for count < rows {
+		batch, err := pr.ReadByNumber(VERIFY_PARQUET_BATCH)
+		if err != nil {
+			return count, unreadable(key, count, err)
+		}
+		if len(batch) < 1 {
+			return count, &Mismatch{key, PROBLEM_TRUNCATED, fmt.Sprintf("%d of %d rows", count, rows)}
+		}
+		count += int64(len(batch))
+	}
+	return count, nil
+}
+
+// Hashes and counts what's read through it
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	n    int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.hash.Write(p[:n])
+	d.n += int64(n)
+	return n, err
+}
+
+// An object that ended partway through a record was cut short, anything else
+// that can't be read is corrupt
+func unreadable(key string, read int64, err error) *Mismatch {
+	problem := PROBLEM_CORRUPT
+	if err == io.ErrUnexpectedEOF || strings.Contains(err.Error(), "unexpected EOF") {
+		problem = PROBLEM_TRUNCATED
+	}
+	return &Mismatch{key, problem, fmt.Sprintf("after %d records: %v", read, err)}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestVerifyEntry(t *testing.T) {
+	var body bytes.Buffer
+	e, err := NewEncoder(&body, Output{Format: FormatNDJSON, Compression: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := e.Write(json.RawMessage(`{"id":1,"message":"something went wrong"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entry := ManifestEntry{Type: "notices", Completed: e.Stats()}
+	entry.Key = "notices.json.gz"
+	written := body.Bytes()
+	corrupt := append([]byte{}, written...)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	tests := []struct {
+		objects  map[string][]byte
+		expected string
+	}{
+		{map[string][]byte{entry.Key: written}, ""},
+		{map[string][]byte{}, PROBLEM_MISSING},
+		{map[string][]byte{entry.Key: written[:len(written)/2]}, PROBLEM_TRUNCATED},
+		{map[string][]byte{entry.Key: corrupt}, PROBLEM_CORRUPT},
+	}
+	for _, test := range tests {
+		mismatch, err := VerifyEntry(&memoryStore{objects: test.objects}, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problem := ""
+		if mismatch != nil {
+			problem = mismatch.Problem
+		}
+		if problem != test.expected {
+			t.Errorf("expected %q but got %q (%v)", test.expected, problem, mismatch)
+		}
+	}
+}
+
+func TestVerifyObjectFindsTruncatedRecords(t *testing.T) {
+	store := &memoryStore{objects: map[string][]byte{
+		"faults.json": []byte("{\"id\":1}\n{\"id\":2}\n{\"id\""),
+	}}
+	count, mismatch, err := VerifyObject(store, "faults.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || mismatch == nil || mismatch.Problem != PROBLEM_TRUNCATED {
+		t.Errorf("expected 2 records and a truncated object but got %d and %v", count, mismatch)
+	}
+}
+
+// A store whose objects can't be read past their first bytes, e.g. as the
+// connection dropped
+type failingStore struct {
+	memoryStore
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func (s *failingStore) Read(key string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(io.MultiReader(strings.NewReader("{\"id\":1}\n"), failingReader{})), nil
+}
+
+func TestVerifyObjectFailsIfTheObjectCantBeRead(t *testing.T) {
+	_, mismatch, err := VerifyObject(&failingStore{}, "faults.json")
+	if err == nil || mismatch != nil {
+		t.Errorf("expected the read error rather than a mismatch but got %v and %v", err, mismatch)
+	}
+}
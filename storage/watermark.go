@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"time"
+)
+
+// How far before a watermark the next run looks again, unless configured
+const DEFAULT_WATERMARK_OVERLAP = 10 * time.Minute
+
+// Watermark works out where the next backup of a stream of records starts
+// from, the latest record time actually seen, rather than when the run
+// happened. Records that arrive late, e.g. while the stream is being backed
+// up, are picked up by looking Overlap before the watermark. The records seen
+// in that overlap are remembered, so they're not archived twice
+type Watermark struct {
+	Prev     int64 // The unix time the previous backup got to
+	Overlap  time.Duration
+	prevSeen map[string]int64
+	max      int64
+	seen     map[string]int64 // Record IDs seen by this backup in the overlap of max, and their unix times
+	pruneAt  int              // Drop what's left the overlap once seen has this many records
+}
+
+// The fewest records seen before those outside the overlap are dropped
+const watermarkPruneMin = 1024
+
+// Starts from the previous watermark and the records seen in its overlap
+func NewWatermark(prev int64, prevSeen map[string]int64, overlap time.Duration) *Watermark {
+	return &Watermark{Prev: prev, Overlap: overlap, prevSeen: prevSeen, max: prev, seen: map[string]int64{}, pruneAt: watermarkPruneMin}
+}
+
+// Carry on from where an interrupted backup got to
+func (w *Watermark) Resume(max int64, seen map[string]int64) {
+	if max > w.max {
+		w.max = max
+	}
+	for id, t := range seen {
+		w.seen[id] = t
+	}
+	w.prune()
+}
+
+// The unix time to request records after
+func (w *Watermark) After() int64 {
+	after := w.Prev - int64(w.Overlap/time.Second)
+	if after < 0 || w.Prev == 0 {
+		return 0
+	}
+	return after
+}
+
+// Whether the previous backup already archived the record with id
+func (w *Watermark) Archived(id string) bool {
+	_, ok := w.prevSeen[id]
+	return ok
+}
+
+// Record a record that was listed, whether or not it was archived. Only the
+// records in the overlap of the latest one are kept, as the max never goes
+// back so older ones can't be in the next backup's overlap
+func (w *Watermark) Add(id string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	u := t.Unix()
+	if u > w.max {
+		w.max = u
+	}
+	if u < w.cutoff() {
+		return
+	}
+	w.seen[id] = u
+	if len(w.seen) >= w.pruneAt {
+		w.prune()
+	}
+}
+
+func (w *Watermark) cutoff() int64 {
+	return w.max - int64(w.Overlap/time.Second)
+}
+
+// Drop the records that have left the overlap since they were added, e.g.
+// when records are listed oldest first
+func (w *Watermark) prune() {
+	cutoff := w.cutoff()
+	for id, t := range w.seen {
+		if t < cutoff {
+			delete(w.seen, id)
+		}
+	}
+	// Don't prune again until seen has doubled, so it's linear overall
+	w.pruneAt = 2 * len(w.seen)
+	if w.pruneAt < watermarkPruneMin {
+		w.pruneAt = watermarkPruneMin
+	}
+}
+
+// The watermark the next backup starts from, and the records seen in the
+// overlap before it. It never goes back
+func (w *Watermark) Next() (int64, map[string]int64) {
+	cutoff := w.cutoff()
+	seen := map[string]int64{}
+	for _, ids := range []map[string]int64{w.prevSeen, w.seen} {
+		for id, t := range ids {
+			if t >= cutoff {
+				seen[id] = t
+			}
+		}
+	}
+	return w.max, seen
+}
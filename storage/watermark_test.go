@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWatermarkOverlapsWithoutDuplicates(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2024, 1, 1, 12, minute, 0, 0, time.UTC)
+	}
+	// The first run sees notices 1 to 3, the last of them at 12:30
+	first := NewWatermark(0, nil, 10*time.Minute)
+	for id, minute := range map[string]int{"1": 0, "2": 25, "3": 30} {
+		first.Add(id, at(minute))
+	}
+	watermark, seen := first.Next()
+	if watermark != at(30).Unix() {
+		t.Errorf("expected the watermark to be the latest notice at 12:30 but got %v", time.Unix(watermark, 0).UTC())
+	}
+	if !reflect.DeepEqual(seen, map[string]int64{"2": at(25).Unix(), "3": at(30).Unix()}) {
+		t.Errorf("expected notices 2 and 3 in the overlap but got %v", seen)
+	}
+
+	// The next run looks again from 12:20, when notice 4 arrived late at 12:28
+	next := NewWatermark(watermark, seen, 10*time.Minute)
+	if next.After() != at(20).Unix() {
+		t.Errorf("expected to look again after 12:20 but got %v", time.Unix(next.After(), 0).UTC())
+	}
+	var archived []string
+	for _, id := range []string{"2", "4", "3"} {
+		if !next.Archived(id) {
+			archived = append(archived, id)
+		}
+	}
+	if !reflect.DeepEqual(archived, []string{"4"}) {
+		t.Errorf("expected only notice 4 to be archived but got %v", archived)
+	}
+
+	// Nothing new, the watermark stays put
+	if unchanged, _ := NewWatermark(watermark, seen, 10*time.Minute).Next(); unchanged != watermark {
+		t.Errorf("expected the watermark to stay at 12:30 but got %v", time.Unix(unchanged, 0).UTC())
+	}
+}
+
+func TestWatermarkOnlyKeepsTheOverlap(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := NewWatermark(0, nil, 10*time.Minute)
+	// A day of records a minute apart, oldest first
+	for i := 0; i < 24*60; i++ {
+		w.Add(strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+	if len(w.seen) > watermarkPruneMin {
+		t.Errorf("expected at most %d records kept but got %d", watermarkPruneMin, len(w.seen))
+	}
+	watermark, seen := w.Next()
+	if watermark != start.Add(24*60*time.Minute-time.Minute).Unix() {
+		t.Errorf("expected the watermark to be the latest record but got %v", time.Unix(watermark, 0).UTC())
+	}
+	if len(seen) != 11 {
+		t.Errorf("expected the 11 records in the last 10 minutes but got %d", len(seen))
+	}
+}
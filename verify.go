@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MasteryConnect/honeybadger-s3/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Verifies the objects listed in the manifest at manifestKey, or when there
+// is no manifest, every object under the prefix. Returns an error if any
+// object is missing, truncated or corrupt
+func verify(ctx *Context, manifestKey string) error {
+	store, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Store = store
+
+	var mismatches []*storage.Mismatch
+	checked := 0
+	if len(manifestKey) > 0 {
+		manifest, err := storage.ReadManifest(ctx.Store, manifestKey)
+		if err != nil {
+			return fmt.Errorf("reading manifest %s: %v", manifestKey, err)
+		}
+		log.WithFields(log.Fields{"manifest": manifestKey, "run": manifest.RunId, "objects": len(manifest.Objects)}).Info("Verifying")
+		for _, entry := range manifest.Objects {
+			mismatch, err := storage.VerifyEntry(ctx.Store, entry)
+			if err != nil {
+				return err
+			}
+			checked++
+			mismatches = appendMismatch(mismatches, mismatch, entry.Key, entry.Records)
+		}
+	} else {
+		objects, err := ctx.Store.List(ctx.S3prefix)
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"prefix": ctx.S3prefix, "objects": len(objects)}).Info("Verifying")
+		for _, object := range objects {
+			if storage.IsManifest(object.Key) || isRunFile(object.Key) {
+				continue
+			}
+			records, mismatch, err := storage.VerifyObject(ctx.Store, object.Key)
+			if err != nil {
+				return err
+			}
+			checked++
+			mismatches = appendMismatch(mismatches, mismatch, object.Key, records)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d objects failed verification", len(mismatches), checked)
+	}
+	log.WithFields(log.Fields{"objects": checked}).Info("All objects verified")
+	return nil
+}
+
+// Logs the result of verifying an object, adding it to mismatches if it failed
+func appendMismatch(mismatches []*storage.Mismatch, mismatch *storage.Mismatch, key string, records int64) []*storage.Mismatch {
+	if mismatch == nil {
+		log.WithFields(log.Fields{"key": key, "records": records}).Debug("Verified")
+		return mismatches
+	}
+	log.WithFields(log.Fields{"key": mismatch.Key, "problem": mismatch.Problem}).Error(mismatch.Detail)
+	return append(mismatches, mismatch)
+}
+
+// Whether the key is of one of the files a run keeps for itself rather than of
+// archived records
+func isRunFile(key string) bool {
+	for _, dir := range []string{CHECKPOINT_DIR, NOTICE_INDEX_DIR} {
+		if strings.Contains("/"+key, "/"+dir+"/") {
+			return true
+		}
+	}
+	for _, name := range []string{STATE_FILE, RUN_DATA_FILE, LOCK_FILE} {
+		if strings.HasSuffix(key, name) {
+			return true
+		}
+	}
+	return false
+}